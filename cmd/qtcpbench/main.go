@@ -0,0 +1,390 @@
+// qtcpbench 基于qtcp的压测与设备模拟工具
+//
+// 示例：
+//
+//	qtcpbench -addr 127.0.0.1:6000 -n 100 -rate 10 -duration 1m \
+//	    -proto fh -head AA -type 01 -body '{"Seq":{{.Seq}},"Value":{{rand 0 100}},"Time":"{{now}}"}'
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qtcp"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+type options struct {
+	Addr       string
+	Conns      int
+	Rate       float64
+	Duration   time.Duration
+	Timeout    time.Duration
+	Proto      string
+	Head       string
+	Tail       string
+	TypeLen    int
+	LenSize    int
+	BigEndian  bool
+	Check      string
+	Type       string
+	Body       string
+	HexBody    bool
+	WaitReply  bool
+	BuffLength int
+}
+
+// bodyData 模板数据
+type bodyData struct {
+	Conn int   // 连接序号 从0开始
+	Seq  int64 // 该连接的发送序号 从1开始
+}
+
+// stats 统计
+type stats struct {
+	sent       int64
+	received   int64
+	sendErrors int64
+	timeouts   int64
+	connErrors int64
+	latencies  []time.Duration
+	mu         sync.Mutex
+}
+
+func (s *stats) addLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latencies = append(s.latencies, d)
+	s.mu.Unlock()
+}
+
+// worker 一个模拟设备连接
+type worker struct {
+	index    int
+	client   qtcp.Client
+	conn     qtcp.Connection // 当前连接，OnLinked时赋值，client的连接此时可能还未赋值
+	pending  []time.Time
+	mu       sync.Mutex
+	linked   chan struct{}
+	linkOnce sync.Once
+	stats    *stats
+}
+
+func (w *worker) OnLinked(c qtcp.Connection) {
+	w.mu.Lock()
+	w.conn = c
+	w.mu.Unlock()
+	w.linkOnce.Do(func() { close(w.linked) })
+}
+
+func (w *worker) OnReceived(c qtcp.Connection, packet qtcp.Packet) {
+	atomic.AddInt64(&w.stats.received, 1)
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	sendTime := w.pending[0]
+	w.pending = w.pending[1:]
+	w.mu.Unlock()
+	w.stats.addLatency(time.Since(sendTime))
+}
+
+func (w *worker) OnClosed(c qtcp.Connection) {
+	w.mu.Lock()
+	atomic.AddInt64(&w.stats.timeouts, int64(len(w.pending)))
+	w.pending = nil
+	w.mu.Unlock()
+}
+
+func (w *worker) OnErrored(e error, c qtcp.Connection) {
+	atomic.AddInt64(&w.stats.connErrors, 1)
+}
+
+// expire 丢弃超时未应答的请求
+func (w *worker) expire(timeout time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for n < len(w.pending) && time.Since(w.pending[n]) > timeout {
+		n++
+	}
+	if n > 0 {
+		atomic.AddInt64(&w.stats.timeouts, int64(n))
+		w.pending = w.pending[n:]
+	}
+}
+
+func main() {
+	opt := parseFlags()
+	protocol, err := newProtocol(opt)
+	if err != nil {
+		exit(err)
+	}
+	typeBytes, err := hex.DecodeString(opt.Type)
+	if err != nil {
+		exit(fmt.Errorf("invalid -type: %s", err))
+	}
+	tpl, err := template.New("body").Funcs(templateFuncs()).Parse(opt.Body)
+	if err != nil {
+		exit(fmt.Errorf("invalid -body: %s", err))
+	}
+
+	st := &stats{}
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+		case <-time.After(opt.Duration):
+		}
+		close(stop)
+	}()
+
+	// 建立连接
+	workers := make([]*worker, opt.Conns)
+	for i := range workers {
+		w := &worker{index: i, linked: make(chan struct{}), stats: st}
+		w.client = qtcp.NewClient(opt.Addr, opt.BuffLength, protocol, w, time.Second, 0)
+		w.client.Start()
+		workers[i] = w
+	}
+
+	// 发送
+	begin := time.Now()
+	wg := &sync.WaitGroup{}
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			run(w, opt, protocol, typeBytes, tpl, stop)
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(begin)
+
+	// 等待最后的应答
+	if opt.WaitReply {
+		deadline := time.Now().Add(opt.Timeout)
+		for time.Now().Before(deadline) && atomic.LoadInt64(&st.received) < atomic.LoadInt64(&st.sent) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, w := range workers {
+			w.expire(0)
+		}
+	}
+	for _, w := range workers {
+		w.client.Stop()
+	}
+	printSummary(opt, st, elapsed)
+}
+
+func run(w *worker, opt options, protocol qtcp.PackProtocol, typeBytes []byte, tpl *template.Template, stop chan struct{}) {
+	select {
+	case <-w.linked:
+	case <-stop:
+		return
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opt.Rate))
+	defer ticker.Stop()
+	var seq int64
+	buf := &bytes.Buffer{}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		seq++
+		buf.Reset()
+		if err := tpl.Execute(buf, bodyData{Conn: w.index, Seq: seq}); err != nil {
+			exit(err)
+		}
+		body := buf.Bytes()
+		if opt.HexBody {
+			b, err := hex.DecodeString(strings.TrimSpace(buf.String()))
+			if err != nil {
+				exit(fmt.Errorf("body is not hex: %s", err))
+			}
+			body = b
+		}
+		pack, err := protocol.BuildFrame(typeBytes, append([]byte(nil), body...))
+		if err != nil {
+			exit(err)
+		}
+		if opt.WaitReply {
+			w.expire(opt.Timeout)
+			w.mu.Lock()
+			w.pending = append(w.pending, time.Now())
+			w.mu.Unlock()
+		}
+		w.mu.Lock()
+		conn := w.conn
+		w.mu.Unlock()
+		if err = conn.Send(pack, opt.Timeout); err != nil {
+			atomic.AddInt64(&w.stats.sendErrors, 1)
+			if opt.WaitReply {
+				w.mu.Lock()
+				if len(w.pending) > 0 {
+					w.pending = w.pending[:len(w.pending)-1]
+				}
+				w.mu.Unlock()
+			}
+			continue
+		}
+		atomic.AddInt64(&w.stats.sent, 1)
+	}
+}
+
+func parseFlags() options {
+	opt := options{}
+	flag.StringVar(&opt.Addr, "addr", "127.0.0.1:6000", "server address")
+	flag.IntVar(&opt.Conns, "n", 1, "number of client connections")
+	flag.Float64Var(&opt.Rate, "rate", 1, "frames per second for each connection")
+	flag.DurationVar(&opt.Duration, "duration", 10*time.Second, "test duration")
+	flag.DurationVar(&opt.Timeout, "timeout", 3*time.Second, "send and reply timeout")
+	flag.StringVar(&opt.Proto, "proto", "fh", "protocol definition, fh (fixed head) or hat (head and tail)")
+	flag.StringVar(&opt.Head, "head", "AA", "frame head in hex")
+	flag.StringVar(&opt.Tail, "tail", "0D0A", "frame tail in hex, hat protocol only")
+	flag.IntVar(&opt.TypeLen, "type-len", 1, "frame type length in bytes")
+	flag.IntVar(&opt.LenSize, "len-size", 16, "body length size in bits, fh protocol only")
+	flag.BoolVar(&opt.BigEndian, "big-endian", true, "body length is big endian, fh protocol only")
+	flag.StringVar(&opt.Check, "check", "crc16", "check type, none, sum or crc16")
+	flag.StringVar(&opt.Type, "type", "01", "frame type to send in hex")
+	flag.StringVar(&opt.Body, "body", `{"Seq":{{.Seq}},"Value":{{rand 0 100}},"Time":"{{now}}"}`,
+		"body template, supports {{.Conn}} {{.Seq}} {{rand min max}} {{randf min max}} {{now}} {{unix}} {{unixMilli}}")
+	flag.BoolVar(&opt.HexBody, "hex", false, "body template renders hex bytes")
+	flag.BoolVar(&opt.WaitReply, "reply", true, "measure round-trip latency using the next received frame as reply")
+	flag.IntVar(&opt.BuffLength, "buff", 1024, "read buffer length")
+	flag.Parse()
+	if opt.Conns <= 0 || opt.Rate <= 0 {
+		exit(errors.New("-n and -rate must be greater than 0"))
+	}
+	// 发送间隔最小为1纳秒
+	if !(opt.Rate <= float64(time.Second)) {
+		exit(errors.New("-rate must not exceed 1e9"))
+	}
+	return opt
+}
+
+func newProtocol(opt options) (qtcp.PackProtocol, error) {
+	head, err := hex.DecodeString(opt.Head)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -head: %s", err)
+	}
+	var check qtcp.ECheckType
+	switch opt.Check {
+	case "none":
+		check = qtcp.ECheckTypeNone
+	case "sum":
+		check = qtcp.ECheckTypeCheckSum
+	case "crc16":
+		check = qtcp.ECheckTypeCRC16
+	default:
+		return nil, fmt.Errorf("unknown -check %s", opt.Check)
+	}
+	switch opt.Proto {
+	case "fh":
+		return qtcp.NewFHProtocol(head, opt.TypeLen, opt.LenSize, opt.BigEndian, check), nil
+	case "hat":
+		tail, err := hex.DecodeString(opt.Tail)
+		if err != nil || len(tail) == 0 {
+			return nil, errors.New("invalid -tail")
+		}
+		return qtcp.NewHatProtocol(head, tail, opt.TypeLen, check), nil
+	}
+	return nil, fmt.Errorf("unknown -proto %s", opt.Proto)
+}
+
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"rand": func(min, max int) int {
+			if max <= min {
+				return min
+			}
+			return min + rand.Intn(max-min)
+		},
+		"randf": func(min, max float64) string {
+			return fmt.Sprintf("%.2f", min+rand.Float64()*(max-min))
+		},
+		"now": func() string {
+			return qconvert.DateTime.ToString(time.Now(), "yyyy-MM-dd HH:mm:ss")
+		},
+		"unix": func() int64 {
+			return time.Now().Unix()
+		},
+		"unixMilli": func() int64 {
+			return time.Now().UnixMilli()
+		},
+	}
+}
+
+func printSummary(opt options, st *stats, elapsed time.Duration) {
+	sent := atomic.LoadInt64(&st.sent)
+	received := atomic.LoadInt64(&st.received)
+	sendErrors := atomic.LoadInt64(&st.sendErrors)
+	timeouts := atomic.LoadInt64(&st.timeouts)
+	total := sent + sendErrors
+
+	fmt.Println("")
+	fmt.Printf("Target:       %s (%s)\n", opt.Addr, opt.Proto)
+	fmt.Printf("Connections:  %d\n", opt.Conns)
+	fmt.Printf("Duration:     %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("Sent:         %d (%.1f/s)\n", sent, float64(sent)/elapsed.Seconds())
+	fmt.Printf("Received:     %d (%.1f/s)\n", received, float64(received)/elapsed.Seconds())
+	fmt.Printf("Send errors:  %d (%.2f%%)\n", sendErrors, percent(sendErrors, total))
+	fmt.Printf("Conn errors:  %d\n", atomic.LoadInt64(&st.connErrors))
+	if !opt.WaitReply {
+		return
+	}
+	fmt.Printf("Timeouts:     %d (%.2f%%)\n", timeouts, percent(timeouts, sent))
+
+	st.mu.Lock()
+	list := st.latencies
+	st.mu.Unlock()
+	if len(list) == 0 {
+		fmt.Println("Latency:      no replies")
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	var sum time.Duration
+	for _, d := range list {
+		sum += d
+	}
+	fmt.Println("Latency:")
+	fmt.Printf("  min  %s\n", list[0])
+	fmt.Printf("  avg  %s\n", sum/time.Duration(len(list)))
+	fmt.Printf("  p50  %s\n", quantile(list, 0.50))
+	fmt.Printf("  p90  %s\n", quantile(list, 0.90))
+	fmt.Printf("  p99  %s\n", quantile(list, 0.99))
+	fmt.Printf("  max  %s\n", list[len(list)-1])
+}
+
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	i := int(float64(len(sorted)-1) * q)
+	return sorted[i]
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, "qtcpbench:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,360 @@
+package qbridge
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qservice"
+	"github.com/kamioair/quick-utils/qtcp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//func main() {
+//	setting := qservice.NewSetting("TcpBridge", "设备接入桥", "1.0.0")
+//	protocol := qtcp.NewFHProtocol([]byte{0xAA}, 1, 16, true, qtcp.ECheckTypeCRC16)
+//	bridge, err := qbridge.New(setting, protocol)
+//	if err != nil {
+//		panic(err)
+//	}
+//	bridge.Run()
+//}
+
+// AttrIdentity 连接属性中保存的业务标识
+const AttrIdentity = "qbridge.identity"
+
+// Frame 设备帧 在微服务中传递
+type Frame struct {
+	ConnId   int64  // 连接号
+	Identity string // 业务标识，如设备序列号
+	Type     string // 帧类型，16进制字符串
+	Body     []byte // 帧内容，json中为base64
+}
+
+// SendArgs Send路由参数
+type SendArgs struct {
+	ConnId    int64  // 连接号，Identity为空时使用
+	Identity  string // 业务标识
+	Type      string // 帧类型，16进制字符串
+	Body      []byte // 帧内容
+	ReplyType string // 等待的应答帧类型，为空时不等待
+	Timeout   int    // 等待应答超时 毫秒，为0时使用配置
+}
+
+// BindArgs Bind路由参数
+type BindArgs struct {
+	ConnId   int64  // 连接号
+	Identity string // 业务标识
+	Kick     bool   // 标识已被其他连接绑定时是否踢掉旧连接
+}
+
+// Bridge 设备TCP连接与微服务之间的桥
+type Bridge struct {
+	serv     *qservice.MicroService
+	server   qtcp.Server
+	protocol qtcp.PackProtocol
+	setting  setting
+	conns    map[int64]qtcp.Connection
+	waiters  map[int64][]*waiter
+	mu       sync.RWMutex
+	stopOnce sync.Once
+}
+
+// waiter 等待设备应答
+type waiter struct {
+	frameType string
+	ch        chan Frame
+	closed    chan struct{} // 连接关闭时关闭
+}
+
+// New 创建桥 会绑定setting的初始化回调和请求回调并创建微服务
+// 桥配置或编解码配置无效时返回错误
+func New(setting *qservice.Setting, protocol qtcp.PackProtocol) (*Bridge, error) {
+	bs, err := loadSetting(setting.Module)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := qtcp.NewCodecFromConfig(setting.Module)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bridge{
+		protocol: protocol,
+		setting:  bs,
+		conns:    make(map[int64]qtcp.Connection),
+		waiters:  make(map[int64][]*waiter),
+	}
+	b.server = qtcp.NewServer(b.setting.Port, time.Second, time.Duration(b.setting.KeepAlive)*time.Millisecond,
+		b.setting.BuffLength, b, protocol)
+	if err = b.server.SetCodec(codec); err != nil {
+		return nil, err
+	}
+	setting.BindInitFunc(b.onInit).BindReqFunc(b.OnReq).OnShutdown(b.onShutdown)
+	b.serv = qservice.NewService(setting)
+	return b, nil
+}
+
+// Run 通过qlauncher启动微服务
+func (b *Bridge) Run() {
+	b.serv.Run()
+}
+
+// Service 返回桥所在的微服务
+func (b *Bridge) Service() *qservice.MicroService {
+	return b.serv
+}
+
+// Server 返回TCP服务端
+func (b *Bridge) Server() qtcp.Server {
+	return b.server
+}
+
+func (b *Bridge) onInit() {
+	go b.server.Start()
+}
+
+// onShutdown 服务退出时停止TCP服务端
+func (b *Bridge) onShutdown(context.Context) {
+	b.stopOnce.Do(b.server.Stop)
+}
+
+// OnReq 桥提供的请求路由 未匹配的路由返回404
+func (b *Bridge) OnReq(route string, ctx qdefine.Context) (any, error) {
+	switch route {
+	case "Send":
+		args, err := qconvert.ToAnyError[SendArgs](ctx.Raw())
+		if err != nil {
+			return nil, qservice.ErrBadReq.WithMessage("invalid arguments").WithCause(err)
+		}
+		return b.Send(args)
+	case "Bind":
+		args, err := qconvert.ToAnyError[BindArgs](ctx.Raw())
+		if err != nil {
+			return nil, qservice.ErrBadReq.WithMessage("invalid arguments").WithCause(err)
+		}
+		return nil, b.Bind(args.ConnId, args.Identity, args.Kick)
+	case "Kick":
+		if !b.server.Kick(ctx.GetString("Identity")) {
+			return nil, qservice.ErrBadReq.WithMessage("identity is not bound")
+		}
+		return nil, nil
+	case "Conns":
+		return b.Conns(), nil
+	}
+	return nil, qservice.ErrRouteNotFind
+}
+
+// Send 向连接发送帧 ReplyType不为空时等待设备应答
+func (b *Bridge) Send(args SendArgs) (*Frame, error) {
+	c, err := b.getConn(args.ConnId, args.Identity)
+	if err != nil {
+		return nil, err
+	}
+	typeBytes, err := hex.DecodeString(args.Type)
+	if err != nil {
+		return nil, err
+	}
+	pack, err := b.protocol.BuildFrame(typeBytes, args.Body)
+	if err != nil {
+		return nil, err
+	}
+	// 先登记等待再发送，避免应答先于登记到达
+	var w *waiter
+	if args.ReplyType != "" {
+		w = b.addWaiter(c.GetId(), args.ReplyType)
+		defer b.removeWaiter(c.GetId(), w)
+	}
+	err = c.Send(pack, time.Duration(b.setting.SendTimeout)*time.Millisecond)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	timeout := args.Timeout
+	if timeout <= 0 {
+		timeout = b.setting.ReplyTimeout
+	}
+	select {
+	case f := <-w.ch:
+		return &f, nil
+	case <-w.closed:
+		return nil, qtcp.ErrConnClosed
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		return nil, qservice.ErrTimeout.WithMessage("device reply timeout")
+	}
+}
+
+// Bind 绑定业务标识到连接
+func (b *Bridge) Bind(connId int64, identity string, kick bool) error {
+	c, err := b.getConn(connId, "")
+	if err != nil {
+		return err
+	}
+	if old := b.server.Bind(identity, c, kick); old != nil && !kick {
+		// 未踢掉旧连接时返回旧连接表示绑定被拒绝
+		return fmt.Errorf("identity %s is bound by connection %d", identity, old.GetId())
+	}
+	c.SetAttr(AttrIdentity, identity)
+	return nil
+}
+
+// Conns 当前所有连接
+func (b *Bridge) Conns() []Frame {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	list := make([]Frame, 0, len(b.conns))
+	for id, c := range b.conns {
+		list = append(list, Frame{ConnId: id, Identity: getIdentity(c)})
+	}
+	return list
+}
+
+func (b *Bridge) getConn(connId int64, identity string) (qtcp.Connection, error) {
+	if identity != "" {
+		if c, ok := b.server.GetConnection(identity); ok {
+			return c, nil
+		}
+		return nil, qtcp.ErrIdentityNotBound
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if c, ok := b.conns[connId]; ok {
+		return c, nil
+	}
+	return nil, qtcp.ErrConnClosed
+}
+
+func (b *Bridge) addWaiter(connId int64, frameType string) *waiter {
+	w := &waiter{frameType: strings.ToUpper(frameType), ch: make(chan Frame, 1), closed: make(chan struct{})}
+	b.mu.Lock()
+	b.waiters[connId] = append(b.waiters[connId], w)
+	b.mu.Unlock()
+	return w
+}
+
+func (b *Bridge) removeWaiter(connId int64, w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := b.waiters[connId]
+	for i, v := range list {
+		if v == w {
+			b.waiters[connId] = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(b.waiters[connId]) == 0 {
+		delete(b.waiters, connId)
+	}
+}
+
+// takeWaiter 取出第一个等待该类型应答的等待者
+func (b *Bridge) takeWaiter(connId int64, frameType string) *waiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := b.waiters[connId]
+	for i, v := range list {
+		if v.frameType == frameType {
+			b.waiters[connId] = append(list[:i], list[i+1:]...)
+			return v
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) findMapping(frameType string) *Mapping {
+	for i, m := range b.setting.Mappings {
+		if m.FrameType == "" || strings.ToUpper(m.FrameType) == frameType {
+			return &b.setting.Mappings[i]
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) OnLinked(c qtcp.Connection) {
+	b.mu.Lock()
+	b.conns[c.GetId()] = c
+	b.mu.Unlock()
+}
+
+func (b *Bridge) OnReceived(c qtcp.Connection, packet qtcp.Packet) {
+	_, body := packet.Split()
+	var typeBytes []byte
+	if tp, ok := packet.(qtcp.TypedPacket); ok {
+		typeBytes = tp.FrameType()
+	}
+	frame := Frame{
+		ConnId:   c.GetId(),
+		Identity: getIdentity(c),
+		Type:     strings.ToUpper(hex.EncodeToString(typeBytes)),
+		Body:     body,
+	}
+	// 优先作为Send的应答
+	if w := b.takeWaiter(frame.ConnId, frame.Type); w != nil {
+		w.ch <- frame
+		return
+	}
+	m := b.findMapping(frame.Type)
+	if m == nil {
+		return
+	}
+	switch m.Kind {
+	case "notice":
+		b.serv.SendNotice(m.Route, frame)
+	case "request":
+		go b.request(c, *m, frame)
+	}
+}
+
+// request 将帧作为请求转发 并将结果作为应答帧回复设备
+func (b *Bridge) request(c qtcp.Connection, m Mapping, frame Frame) {
+	ctx, err := b.serv.SendRequest(m.Module, m.Route, frame)
+	if err != nil {
+		b.serv.SendLog(qdefine.ELogError, fmt.Sprintf("Bridge Request %s.%s Error", m.Module, m.Route), err)
+		return
+	}
+	reply, err := qconvert.ToAnyError[Frame](ctx.Raw())
+	if err != nil {
+		b.serv.SendLog(qdefine.ELogError, fmt.Sprintf("Bridge Reply %s.%s Error", m.Module, m.Route), err)
+		return
+	}
+	if reply.Type == "" {
+		reply.Type = m.ReplyType
+	}
+	if reply.Type == "" && len(reply.Body) == 0 {
+		return
+	}
+	_, err = b.Send(SendArgs{ConnId: c.GetId(), Type: reply.Type, Body: reply.Body})
+	if err != nil {
+		b.serv.SendLog(qdefine.ELogError, "Bridge Send Reply Error", err)
+	}
+}
+
+func (b *Bridge) OnClosed(c qtcp.Connection) {
+	b.mu.Lock()
+	delete(b.conns, c.GetId())
+	// 通知等待该连接应答的Send
+	for _, w := range b.waiters[c.GetId()] {
+		close(w.closed)
+	}
+	delete(b.waiters, c.GetId())
+	b.mu.Unlock()
+}
+
+func (b *Bridge) OnErrored(e error, c qtcp.Connection) {
+	if b.serv == nil {
+		return
+	}
+	if c != nil {
+		b.serv.SendLog(qdefine.ELogWarn, fmt.Sprintf("Bridge Connection %d Error, %s", c.GetId(), e), nil)
+		return
+	}
+	b.serv.SendLog(qdefine.ELogWarn, fmt.Sprintf("Bridge Server Error, %s", e), nil)
+}
+
+func getIdentity(c qtcp.Connection) string {
+	if v, ok := c.GetAttr(AttrIdentity); ok {
+		return v.(string)
+	}
+	return ""
+}
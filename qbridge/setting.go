@@ -0,0 +1,60 @@
+package qbridge
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kamioair/quick-utils/qconfig"
+	"strings"
+)
+
+// Mapping 帧与微服务的映射
+type Mapping struct {
+	FrameType string // 帧类型，16进制字符串，为空时匹配所有帧
+	Kind      string // 转发方式，notice|request
+	Module    string // 请求的目标模块，Kind为request时有效
+	Route     string // 通知或请求的路由
+	ReplyType string // 请求应答帧的默认类型，16进制字符串
+}
+
+type setting struct {
+	Port         int       // 监听端口
+	BuffLength   int       // 读缓冲长度
+	KeepAlive    int       // 心跳间隔 毫秒
+	SendTimeout  int       // 发送超时 毫秒
+	ReplyTimeout int       // 等待设备应答超时 毫秒
+	Mappings     []Mapping // 帧映射
+}
+
+// loadSetting 读取桥的配置 端口或映射无效时返回错误
+func loadSetting(module string) (setting, error) {
+	s := setting{
+		Port:         qconfig.Get(module, "bridge.port", 6000),
+		BuffLength:   qconfig.Get(module, "bridge.buffLength", 1024),
+		KeepAlive:    qconfig.Get(module, "bridge.keepAlive", 30000),
+		SendTimeout:  qconfig.Get(module, "bridge.sendTimeout", 3000),
+		ReplyTimeout: qconfig.Get(module, "bridge.replyTimeout", 3000),
+		Mappings:     qconfig.Get(module, "bridge.mappings", []Mapping{}),
+	}
+	if s.Port <= 0 || s.Port > 65535 {
+		return s, fmt.Errorf("bridge.port %d invalid", s.Port)
+	}
+	for i, m := range s.Mappings {
+		if _, err := hex.DecodeString(m.FrameType); err != nil {
+			return s, fmt.Errorf("bridge.mappings[%d] frameType %q invalid, %w", i, m.FrameType, err)
+		}
+		if _, err := hex.DecodeString(m.ReplyType); err != nil {
+			return s, fmt.Errorf("bridge.mappings[%d] replyType %q invalid, %w", i, m.ReplyType, err)
+		}
+		switch strings.ToLower(m.Kind) {
+		case "notice":
+		case "request":
+			if m.Module == "" {
+				return s, fmt.Errorf("bridge.mappings[%d] module is empty", i)
+			}
+		default:
+			return s, fmt.Errorf("bridge.mappings[%d] kind %q invalid, must be notice or request", i, m.Kind)
+		}
+		s.Mappings[i].Kind = strings.ToLower(m.Kind)
+	}
+	return s, nil
+}
@@ -2,12 +2,13 @@ package qdefine
 
 // BrokerConfig 主服务配置
 type BrokerConfig struct {
-	Addr    string
-	UId     string
-	Pwd     string
-	LogMode string
-	TimeOut int
-	Retry   int
+	Addr       string
+	UId        string
+	Pwd        string
+	LogMode    string
+	TimeOut    int // 请求超时时间 毫秒
+	Retry      int // 请求次数
+	MaxTimeOut int // 单次请求的最长等待时间 毫秒
 }
 
 type (
@@ -1,24 +1,99 @@
 package qdefine
 
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
 // File 文件
+// 经过json传输时Data为base64字符串，例如 {"Name":"a.txt","Size":5,"Data":"aGVsbG8=","Sha256":"..."}
+// 大文件使用qservice的UploadFile、DownloadFile分块传输
 type File struct {
-	Name string // 文件名
-	Size int64  // 文件大小
-	Data []byte // 内容
+	Name   string // 文件名
+	Size   int64  // 文件大小
+	Data   []byte // 内容
+	Sha256 string `json:",omitempty"` // 内容的sha256 16进制，不为空时接收方校验
+}
+
+// NewFile 创建文件 计算大小和sha256
+func NewFile(name string, data []byte) File {
+	sum := sha256.Sum256(data)
+	return File{Name: name, Size: int64(len(data)), Data: data, Sha256: hex.EncodeToString(sum[:])}
+}
+
+// Verify 校验文件大小和sha256 Sha256为空时只校验大小
+func (f File) Verify() error {
+	if f.Size != int64(len(f.Data)) {
+		return fmt.Errorf("file %s size %d, but data length %d", f.Name, f.Size, len(f.Data))
+	}
+	if f.Sha256 == "" {
+		return nil
+	}
+	if sum := sha256.Sum256(f.Data); hex.EncodeToString(sum[:]) != f.Sha256 {
+		return fmt.Errorf("file %s checksum mismatch", f.Name)
+	}
+	return nil
 }
 
 // Context 上下文
+// key支持多级路径，例如 Items[2].Name、Info.Address.City，每级名称不区分大小写
 type Context interface {
 	GetString(key string) string
 	GetInt(key string) int
 	GetUInt(key string) uint64
 	GetByte(key string) byte
 	GetBool(key string) bool
+	GetFloat(key string) float64
 	GetDate(key string) Date
 	GetDateTime(key string) DateTime
+	GetDuration(key string) time.Duration
+	GetSlice(key string) []any
+	GetMap(key string) map[string]any
 	GetFiles(key string) []File
 	GetStruct(refStruct any)
 	Raw() any
+
+	// 不会抛出异常的获取方法，键不存在或转换失败时返回错误
+	Has(key string) bool
+	TryGetString(key string) (string, error)
+	TryGetInt(key string) (int, error)
+	TryGetUInt(key string) (uint64, error)
+	TryGetByte(key string) (byte, error)
+	TryGetBool(key string) (bool, error)
+	TryGetFloat(key string) (float64, error)
+	TryGetDate(key string) (Date, error)
+	TryGetDateTime(key string) (DateTime, error)
+	TryGetDuration(key string) (time.Duration, error)
+
+	// 带默认值的获取方法，键不存在或转换失败时返回默认值
+	GetStringOr(key string, def string) string
+	GetIntOr(key string, def int) int
+	GetUIntOr(key string, def uint64) uint64
+	GetBoolOr(key string, def bool) bool
+	GetFloatOr(key string, def float64) float64
+	GetDurationOr(key string, def time.Duration) time.Duration
+
+	// 请求元数据，通知上下文的SendTime、Timeout无效
+	Source() string           // 调用方模块名称
+	RequestId() uint64        // 请求Id
+	Route() string            // 请求路由
+	SendTime() time.Time      // 调用方发送时间
+	Timeout() time.Duration   // 调用方剩余等待时长，没有截止时间时返回0
+	Context() context.Context // 截止时间为调用方超时时间的上下文，调用方放弃后即被取消，携带链路标识
+	TraceId() string          // 链路Id
+	SpanId() string           // 当前处理的调用段Id
+	Logger() Logger           // 附加了链路Id和调用段Id的模块日志
+}
+
+// Logger 日志 kv为键值对，例如 "User", "admin", "Error", err，由qlog.Logger实现
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
 }
 
 // Date 日期
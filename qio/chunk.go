@@ -0,0 +1,99 @@
+package qio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileSize
+//
+//	@Description: 获取文件大小，文件不存在时返回0
+//	@param filename
+//	@return int64
+func FileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil || info.IsDir() {
+		return 0
+	}
+	return info.Size()
+}
+
+// FileSha256
+//
+//	@Description: 流式计算文件内容的sha256，不会将整个文件读入内存
+//	@param filename
+//	@return string 16进制小写
+//	@return error
+func FileSha256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadChunk
+//
+//	@Description: 从指定位置读取一段内容，到达文件末尾时返回的内容少于size
+//	@param filename
+//	@param offset 起始位置
+//	@param size 最大长度
+//	@return []byte
+//	@return error
+func ReadChunk(filename string, offset int64, size int) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// WriteChunk
+//
+//	@Description: 在指定位置写入一段内容并截断之后的内容，文件不存在时创建
+//	              重复写入同一段内容的结果相同，便于断点续传时重试
+//	@param filename
+//	@param offset 起始位置，不能超过文件当前大小
+//	@param data
+//	@return int64 写入后的文件大小
+//	@return error
+func WriteChunk(filename string, offset int64, data []byte) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if offset > info.Size() {
+		return info.Size(), errors.New("chunk offset beyond file size")
+	}
+	if _, err = f.WriteAt(data, offset); err != nil {
+		return 0, err
+	}
+	end := offset + int64(len(data))
+	if err = f.Truncate(end); err != nil {
+		return 0, err
+	}
+	return end, f.Sync()
+}
@@ -1,6 +1,7 @@
 package qio
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -343,3 +344,53 @@ func formatPath(path string) string {
 	path = strings.Replace(path, "//", "/", -1)
 	return path
 }
+
+// EachLineReverse
+//
+//	@Description: 从文件末尾开始按块向前读取，从后往前依次将每一行交给fn，fn返回false时停止
+//	              不会将整个文件读入内存，空行会被跳过
+//	@param filename
+//	@param fn line在fn返回后仍然有效
+//	@return error
+func EachLineReverse(filename string, fn func(line []byte) bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	const blockSize = 64 * 1024
+	buf := make([]byte, blockSize)
+	pos := info.Size()
+	var rest []byte // 当前块之后不完整的行
+	for pos > 0 {
+		n := int64(blockSize)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		if _, err = f.ReadAt(buf[:n], pos); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		data := append(append(make([]byte, 0, int(n)+len(rest)), buf[:n]...), rest...)
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			line := data[i+1:]
+			data = data[:i]
+			if len(line) > 0 && !fn(line) {
+				return nil
+			}
+		}
+		rest = data
+	}
+	if len(rest) > 0 {
+		fn(rest)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package qio
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateTimeFormat 备份文件名中的时间格式
+const rotateTimeFormat = "20060102-150405.000"
+
+// RotateWriter 按大小和时间滚动的文件写入器
+// 当前文件超过MaxSize时重命名为 文件名-时间.扩展名 的备份，再新建文件继续写入
+type RotateWriter struct {
+	Path       string        // 当前文件路径
+	MaxSize    int64         // 单个文件的最大字节数 小于等于0时不按大小滚动
+	MaxAge     time.Duration // 备份保留时长 小于等于0时不按时间清理
+	MaxBackups int           // 备份保留个数 小于等于0时不按个数清理
+	file       *os.File
+	size       int64
+	lock       sync.Mutex
+}
+
+var (
+	rotateWriters = make(map[string]*RotateWriter)
+	rotateLock    sync.Mutex
+)
+
+// NewRotateWriter
+//
+//	@Description: 创建滚动文件写入器
+//	@param path 文件路径
+//	@param maxSize 单个文件的最大字节数
+//	@param maxAge 备份保留时长
+//	@param maxBackups 备份保留个数
+//	@return *RotateWriter
+func NewRotateWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) *RotateWriter {
+	return &RotateWriter{
+		Path:       GetFullPath(path),
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+}
+
+// GetRotateWriter
+//
+//	@Description: 获取路径对应的共享写入器，同一进程内相同路径只创建一个写入器，
+//	              避免多个写入器各自滚动同一个文件，滚动参数以首次创建时为准
+//	@param path 文件路径
+//	@param maxSize 单个文件的最大字节数
+//	@param maxAge 备份保留时长
+//	@param maxBackups 备份保留个数
+//	@return *RotateWriter
+func GetRotateWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) *RotateWriter {
+	full := GetFullPath(path)
+	rotateLock.Lock()
+	defer rotateLock.Unlock()
+	if w, ok := rotateWriters[full]; ok {
+		return w
+	}
+	w := NewRotateWriter(full, maxSize, maxAge, maxBackups)
+	rotateWriters[full] = w
+	return w
+}
+
+// Write
+//
+//	@Description: 写入内容，写入后超过最大字节数时滚动
+//	@param p
+//	@return int
+//	@return error
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close
+//
+//	@Description: 关闭当前文件
+//	@return error
+func (w *RotateWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Files
+//
+//	@Description: 获取当前文件和所有备份，按从新到旧排序
+//	@return []string
+func (w *RotateWriter) Files() []string {
+	list := make([]string, 0)
+	if PathExists(w.Path) {
+		list = append(list, w.Path)
+	}
+	return append(list, w.backups()...)
+}
+
+func (w *RotateWriter) open() error {
+	if _, err := CreateDirectory(filepath.Dir(w.Path)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.Path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	ext := filepath.Ext(w.Path)
+	backup := strings.TrimSuffix(w.Path, ext) + "-" + time.Now().Format(rotateTimeFormat) + ext
+	if err := os.Rename(w.Path, backup); err != nil {
+		return err
+	}
+	w.clean()
+	return w.open()
+}
+
+// clean 删除超过保留时长和个数的备份
+func (w *RotateWriter) clean() {
+	for i, backup := range w.backups() {
+		expired := false
+		if w.MaxBackups > 0 && i >= w.MaxBackups {
+			expired = true
+		}
+		if w.MaxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > w.MaxAge {
+				expired = true
+			}
+		}
+		if expired {
+			_ = os.Remove(backup)
+		}
+	}
+}
+
+// backups 所有备份 按从新到旧排序
+func (w *RotateWriter) backups() []string {
+	ext := filepath.Ext(w.Path)
+	files, err := filepath.Glob(strings.TrimSuffix(w.Path, ext) + "-*" + ext)
+	if err != nil {
+		return nil
+	}
+	// 时间格式按字典序即为时间顺序
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files
+}
@@ -3,14 +3,15 @@ package qlauncher
 import (
 	"fmt"
 	"github.com/kamioair/quick-utils/qio"
+	"github.com/kamioair/quick-utils/qlog"
 	"github.com/kardianos/service"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,7 +32,9 @@ import (
 
 var (
 	stopChan = make(chan struct{}, 1)
+	stopOnce = &sync.Once{}
 	wg       = &sync.WaitGroup{}
+	running  int32 // 服务已通过Run启动且未停止
 )
 
 // Run 运行服务
@@ -44,14 +47,32 @@ func RunEx(start func(param interface{}), param interface{}, stop func()) {
 	setup(nil, start, param, stop)
 }
 
-// Exit 退出服务
-func Exit() {
+// Running 服务是否已通过Run启动且未停止
+func Running() bool {
+	return atomic.LoadInt32(&running) == 1
+}
+
+// Exit 退出服务 延迟100毫秒，以便当前请求先返回
+// 执行外层停止后再退出，与系统停止信号同时触发时外层停止只执行一次
+// 服务不是通过Run启动时不做处理并返回false，由调用方自行停止
+func Exit() bool {
+	if !Running() {
+		return false
+	}
 	go func() {
 		time.Sleep(time.Millisecond * 100)
-		close(stopChan)
+		closeStop()
 		wg.Wait()
 		os.Exit(0)
 	}()
+	return true
+}
+
+// closeStop 通知run协程执行外层停止
+func closeStop() {
+	stopOnce.Do(func() {
+		close(stopChan)
+	})
 }
 
 type program struct {
@@ -79,7 +100,8 @@ func setup(start func(), startEx func(param interface{}), param interface{}, sto
 		Name: n1[len(n1)-1] + "_" + n2,
 	})
 	if err != nil {
-		log.Fatalln(err)
+		qlog.Error("launcher create error", "Error", err)
+		os.Exit(1)
 		return
 	}
 	// 修改当前工作目录为exe所在目录
@@ -87,7 +109,8 @@ func setup(start func(), startEx func(param interface{}), param interface{}, sto
 	dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
 	err = os.Chdir(dir)
 	if err != nil {
-		log.Fatalln(err)
+		qlog.Error("launcher chdir error", "Error", err)
+		os.Exit(1)
 		return
 	}
 
@@ -99,9 +122,9 @@ func setup(start func(), startEx func(param interface{}), param interface{}, sto
 			if qio.PathExists(fmt.Sprintf("/lib/systemd/system/%s.service", serv.String())) {
 				err = serv.Install()
 				if err != nil {
-					log.Println(fmt.Sprintf("[%s] Installed Error, %s", serv.String(), err))
+					qlog.Error("service install error", "Service", serv.String(), "Error", err)
 				} else {
-					log.Println(fmt.Sprintf("[%s] Installed OK", serv.String()))
+					qlog.Info("service installed", "Service", serv.String())
 				}
 			}
 		}
@@ -109,7 +132,7 @@ func setup(start func(), startEx func(param interface{}), param interface{}, sto
 	// 运行
 	err = serv.Run()
 	if err != nil {
-		log.Println(err)
+		qlog.Error("service run error", "Error", err)
 		return
 	}
 }
@@ -124,10 +147,12 @@ func (p *program) Start(s service.Service) error {
 	}
 
 	// 启动成功
-	log.Println(fmt.Sprintf("[%s] Started OK", s.String()))
+	qlog.Info("service started", "Service", s.String())
 
 	wg.Add(1)
+	atomic.StoreInt32(&running, 1)
 	stopChan = make(chan struct{})
+	stopOnce = &sync.Once{}
 	go p.run()
 
 	return nil
@@ -141,6 +166,7 @@ func (p *program) run() {
 			if p.stop != nil {
 				p.stop()
 			}
+			atomic.StoreInt32(&running, 0)
 			// 全部退出完成
 			wg.Done()
 			return
@@ -150,8 +176,8 @@ func (p *program) run() {
 
 func (p *program) Stop(s service.Service) error {
 	// 启动成功
-	log.Println(fmt.Sprintf("[%s] Stoped OK", s.String()))
-	close(stopChan)
+	qlog.Info("service stopped", "Service", s.String())
+	closeStop()
 	wg.Wait()
 	return nil
 }
@@ -0,0 +1,294 @@
+package qlog
+
+import (
+	"fmt"
+	"github.com/kamioair/quick-utils/qconfig"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 使用方式
+//
+//	logger := qlog.Load("Module")
+//	logger.Info("user login", "User", "admin", "Ip", "127.0.0.1")
+//	logger.With("Conn", id).Error("conn closed", "Error", err)
+//
+//	// 默认日志，qservice.NewService会将其替换为当前模块的日志
+//	qlog.Warn("relink")
+
+// ELevel 日志级别
+type ELevel int32
+
+const (
+	LevelDebug ELevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff // 关闭日志
+)
+
+var levelNames = map[ELevel]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+	LevelOff:   "OFF",
+}
+
+func (l ELevel) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int32(l))
+}
+
+// ParseLevel
+//
+//	@Description: 解析日志级别，不区分大小写
+//	@param str debug|info|warn|error|off
+//	@return ELevel
+//	@return error
+func ParseLevel(str string) (ELevel, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	if str == "WARNING" {
+		return LevelWarn, nil
+	}
+	for l, name := range levelNames {
+		if name == str {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", str)
+}
+
+// Entry 日志记录
+type Entry struct {
+	Time    time.Time
+	Level   ELevel
+	Module  string
+	Message string
+	Fields  []Field // 按添加顺序排列的结构化字段
+}
+
+// Field 结构化字段
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Logger 日志 同一模块的Logger共享级别和输出
+type Logger struct {
+	core   *core
+	fields []Field
+}
+
+// core 模块的日志配置
+type core struct {
+	module string
+	level  atomic.Int32
+	sinks  []Sink
+	lock   sync.RWMutex
+}
+
+var (
+	loggers    = map[string]*Logger{}
+	loggerLock sync.Mutex
+	defLogger  atomic.Pointer[Logger]
+)
+
+func init() {
+	defLogger.Store(New("", LevelInfo, NewConsoleSink()))
+}
+
+// New
+//
+//	@Description: 创建日志，同名模块的日志会被替换
+//	@param module 模块名称
+//	@param level 日志级别
+//	@param sinks 输出
+//	@return *Logger
+func New(module string, level ELevel, sinks ...Sink) *Logger {
+	c := &core{module: module, sinks: sinks}
+	c.level.Store(int32(level))
+	logger := &Logger{core: c}
+	loggerLock.Lock()
+	loggers[module] = logger
+	loggerLock.Unlock()
+	return logger
+}
+
+// Load
+//
+//	@Description: 按配置文件创建模块日志，配置见config.yaml的log节点
+//	@param module 模块名称
+//	@return *Logger
+func Load(module string) *Logger {
+	level, err := ParseLevel(qconfig.Get(module, "log.level", "info"))
+	if err != nil {
+		level = LevelInfo
+	}
+	sinks := make([]Sink, 0)
+	if qconfig.Get(module, "log.console", true) {
+		sinks = append(sinks, NewConsoleSink())
+	}
+	if path := qconfig.Get(module, "log.file.path", ""); path != "" {
+		sinks = append(sinks, NewFileSink(path,
+			qconfig.Get(module, "log.file.maxSize", 10),
+			qconfig.Get(module, "log.file.maxAge", 30),
+			qconfig.Get(module, "log.file.maxBackups", 10)))
+	}
+	return New(module, level, sinks...)
+}
+
+// Get
+//
+//	@Description: 获取已创建的模块日志，不存在时返回默认日志
+//	@param module 模块名称
+//	@return *Logger
+func Get(module string) *Logger {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+	if logger, ok := loggers[module]; ok {
+		return logger
+	}
+	return Default()
+}
+
+// SetLevel
+//
+//	@Description: 运行时修改模块的日志级别
+//	@param module 模块名称
+//	@param level 日志级别
+//	@return bool 模块日志是否存在
+func SetLevel(module string, level ELevel) bool {
+	loggerLock.Lock()
+	logger, ok := loggers[module]
+	loggerLock.Unlock()
+	if ok {
+		logger.SetLevel(level)
+	}
+	return ok
+}
+
+// Default
+//
+//	@Description: 获取默认日志
+//	@return *Logger
+func Default() *Logger {
+	return defLogger.Load()
+}
+
+// SetDefault
+//
+//	@Description: 替换默认日志，各工具包的日志都输出到默认日志
+//	@param logger
+func SetDefault(logger *Logger) {
+	if logger != nil {
+		defLogger.Store(logger)
+	}
+}
+
+// Module 模块名称
+func (l *Logger) Module() string {
+	return l.core.module
+}
+
+// Level 当前日志级别
+func (l *Logger) Level() ELevel {
+	return ELevel(l.core.level.Load())
+}
+
+// SetLevel 修改日志级别 对同一模块的所有Logger生效
+func (l *Logger) SetLevel(level ELevel) {
+	l.core.level.Store(int32(level))
+}
+
+// Enabled 判断级别是否会输出
+func (l *Logger) Enabled(level ELevel) bool {
+	return level >= l.Level() && level < LevelOff
+}
+
+// AddSink 添加输出 对同一模块的所有Logger生效
+func (l *Logger) AddSink(sinks ...Sink) *Logger {
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	l.core.sinks = append(append([]Sink{}, l.core.sinks...), sinks...)
+	return l
+}
+
+// With 返回附加了字段的Logger kv为键值对
+func (l *Logger) With(kv ...any) *Logger {
+	return &Logger{
+		core:   l.core,
+		fields: append(append([]Field{}, l.fields...), toFields(kv)...),
+	}
+}
+
+func (l *Logger) Debug(msg string, kv ...any) {
+	l.Log(LevelDebug, msg, kv...)
+}
+
+func (l *Logger) Info(msg string, kv ...any) {
+	l.Log(LevelInfo, msg, kv...)
+}
+
+func (l *Logger) Warn(msg string, kv ...any) {
+	l.Log(LevelWarn, msg, kv...)
+}
+
+func (l *Logger) Error(msg string, kv ...any) {
+	l.Log(LevelError, msg, kv...)
+}
+
+// Log 输出日志 kv为键值对，例如 "User", "admin", "Error", err
+func (l *Logger) Log(level ELevel, msg string, kv ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+	entry := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Module:  l.core.module,
+		Message: msg,
+		Fields:  append(append([]Field{}, l.fields...), toFields(kv)...),
+	}
+	l.core.lock.RLock()
+	sinks := l.core.sinks
+	l.core.lock.RUnlock()
+	for _, sink := range sinks {
+		sink.Write(entry)
+	}
+}
+
+// toFields 键值对转为字段 键不是字符串或缺少值时使用!BADKEY
+func toFields(kv []any) []Field {
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || i+1 >= len(kv) {
+			fields = append(fields, Field{Key: "!BADKEY", Value: kv[i]})
+			i--
+			continue
+		}
+		fields = append(fields, Field{Key: key, Value: kv[i+1]})
+	}
+	return fields
+}
+
+func Debug(msg string, kv ...any) {
+	Default().Log(LevelDebug, msg, kv...)
+}
+
+func Info(msg string, kv ...any) {
+	Default().Log(LevelInfo, msg, kv...)
+}
+
+func Warn(msg string, kv ...any) {
+	Default().Log(LevelWarn, msg, kv...)
+}
+
+func Error(msg string, kv ...any) {
+	Default().Log(LevelError, msg, kv...)
+}
@@ -0,0 +1,134 @@
+package qlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/kamioair/quick-utils/qio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat 日志时间格式
+const timeFormat = "2006-01-02 15:04:05.000"
+
+// Sink 日志输出
+type Sink interface {
+	Write(entry *Entry)
+}
+
+// FuncSink 方法形式的输出 例如上传到服务器
+type FuncSink func(entry *Entry)
+
+func (f FuncSink) Write(entry *Entry) {
+	f(entry)
+}
+
+// consoleSink 控制台输出 按级别着色
+type consoleSink struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+var levelColors = map[ELevel]*color.Color{
+	LevelDebug: color.New(color.FgCyan),
+	LevelInfo:  color.New(color.FgGreen),
+	LevelWarn:  color.New(color.FgYellow),
+	LevelError: color.New(color.FgRed, color.Bold),
+}
+
+// NewConsoleSink
+//
+//	@Description: 创建控制台输出，格式为 时间 [级别] [模块] 内容 键=值
+//	@return Sink
+func NewConsoleSink() Sink {
+	return &consoleSink{out: color.Output}
+}
+
+func (s *consoleSink) Write(entry *Entry) {
+	sb := strings.Builder{}
+	sb.WriteString(color.New(color.FgWhite).Sprint(entry.Time.Format(timeFormat)))
+	sb.WriteString(" ")
+	level := fmt.Sprintf("[%s]", entry.Level)
+	if c, ok := levelColors[entry.Level]; ok {
+		level = c.Sprint(level)
+	}
+	sb.WriteString(level)
+	if entry.Module != "" {
+		sb.WriteString(fmt.Sprintf(" [%s]", entry.Module))
+	}
+	sb.WriteString(" ")
+	sb.WriteString(entry.String())
+	sb.WriteString("\n")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, _ = io.WriteString(s.out, sb.String())
+}
+
+// fileSink 文件输出 每条日志为一行json
+type fileSink struct {
+	writer io.Writer
+}
+
+// NewFileSink
+//
+//	@Description: 创建按大小滚动的文件输出，每条日志为一行json，相同路径共享一个写入器
+//	@param path 文件路径
+//	@param maxSize 单个文件的最大大小 MB
+//	@param maxAge 备份保留天数
+//	@param maxBackups 备份保留个数
+//	@return Sink
+func NewFileSink(path string, maxSize, maxAge, maxBackups int) Sink {
+	return &fileSink{
+		writer: qio.GetRotateWriter(path, int64(maxSize)*1024*1024, time.Duration(maxAge)*24*time.Hour, maxBackups),
+	}
+}
+
+// NewWriterSink
+//
+//	@Description: 创建输出到指定Writer的输出，每条日志为一行json
+//	@param writer
+//	@return Sink
+func NewWriterSink(writer io.Writer) Sink {
+	return &fileSink{writer: writer}
+}
+
+func (s *fileSink) Write(entry *Entry) {
+	js, err := json.Marshal(entry)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "qlog marshal error, %s\n", err)
+		return
+	}
+	_, _ = s.writer.Write(append(js, '\n'))
+}
+
+// MarshalJSON 字段展开到顶层 error类型的值输出错误信息
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(e.Fields)+4)
+	for _, f := range e.Fields {
+		if err, ok := f.Value.(error); ok {
+			m[f.Key] = err.Error()
+		} else {
+			m[f.Key] = f.Value
+		}
+	}
+	m["Time"] = e.Time.Format(timeFormat)
+	m["Level"] = e.Level.String()
+	m["Module"] = e.Module
+	m["Message"] = e.Message
+	return json.Marshal(m)
+}
+
+// String 转为 内容 键=值 形式的文本
+func (e *Entry) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(e.Message)
+	for _, f := range e.Fields {
+		sb.WriteString(fmt.Sprintf(" %s=%v", f.Key, f.Value))
+	}
+	return sb.String()
+}
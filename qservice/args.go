@@ -0,0 +1,169 @@
+package qservice
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/kamioair/quick-utils/qdefine"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 启动参数 优先级从高到低为 命令行参数 > QF_*环境变量 > JSON参数 > 配置文件
+//
+//	./module --module=Demo --device-code=ABC --mq-addr=ws://10.0.0.1:5002/ws
+//	./module '{"Module":"Demo","DeviceCode":"ABC","MqConfig":{...}}' --mq-retry=1
+//	QF_MQ_ADDR=ws://10.0.0.1:5002/ws ./module --print-config
+
+// args JSON参数 兼容旧版本，必须是第一个参数
+type args struct {
+	Module     string
+	DeviceCode string
+	ConfigPath string
+	MqConfig   *qdefine.BrokerConfig
+}
+
+// argDef 命令行参数和对应的环境变量
+type argDef struct {
+	name  string
+	env   string
+	usage string
+	apply func(a *startArgs, value string) error
+}
+
+// startArgs 合并后的启动参数
+type startArgs struct {
+	args
+	broker      []func(b *qdefine.BrokerConfig) // 按优先级从低到高覆盖主服务配置
+	printConfig bool
+}
+
+var argDefs = []argDef{
+	{"module", "QF_MODULE", "模块名称", func(a *startArgs, v string) error {
+		a.Module = v
+		return nil
+	}},
+	{"device-code", "QF_DEVICE_CODE", "设备码", func(a *startArgs, v string) error {
+		a.DeviceCode = v
+		return nil
+	}},
+	{"config", "QF_CONFIG", "配置文件路径", func(a *startArgs, v string) error {
+		a.ConfigPath = v
+		return nil
+	}},
+	{"mq-addr", "QF_MQ_ADDR", "MQTT服务器地址", brokerArg(func(b *qdefine.BrokerConfig, v string) error {
+		b.Addr = v
+		return nil
+	})},
+	{"mq-user", "QF_MQ_USER", "MQTT用户名", brokerArg(func(b *qdefine.BrokerConfig, v string) error {
+		b.UId = v
+		return nil
+	})},
+	{"mq-pwd", "QF_MQ_PWD", "MQTT密码", brokerArg(func(b *qdefine.BrokerConfig, v string) error {
+		b.Pwd = v
+		return nil
+	})},
+	{"mq-log-mode", "QF_MQ_LOG_MODE", "MQTT日志模式 NONE|CONSOLE|FILE|UPLOAD|ALL", brokerArg(func(b *qdefine.BrokerConfig, v string) error {
+		b.LogMode = v
+		return nil
+	})},
+	{"mq-timeout", "QF_MQ_TIMEOUT", "请求超时时间 毫秒", brokerArg(func(b *qdefine.BrokerConfig, v string) (err error) {
+		b.TimeOut, err = strconv.Atoi(v)
+		return err
+	})},
+	{"mq-retry", "QF_MQ_RETRY", "请求次数", brokerArg(func(b *qdefine.BrokerConfig, v string) (err error) {
+		b.Retry, err = strconv.Atoi(v)
+		return err
+	})},
+	{"mq-max-timeout", "QF_MQ_MAX_TIMEOUT", "单次请求的最长等待时间 毫秒", brokerArg(func(b *qdefine.BrokerConfig, v string) (err error) {
+		b.MaxTimeOut, err = strconv.Atoi(v)
+		return err
+	})},
+}
+
+// brokerArg 主服务配置的参数 先校验值，在读取配置文件后再覆盖
+func brokerArg(set func(b *qdefine.BrokerConfig, v string) error) func(a *startArgs, v string) error {
+	return func(a *startArgs, v string) error {
+		if err := set(&qdefine.BrokerConfig{}, v); err != nil {
+			return err
+		}
+		a.broker = append(a.broker, func(b *qdefine.BrokerConfig) {
+			_ = set(b, v)
+		})
+		return nil
+	}
+}
+
+// parseArgs 解析启动参数 --help时返回flag.ErrHelp
+func parseArgs(list []string, output io.Writer) (*startArgs, error) {
+	a := &startArgs{}
+	// JSON参数
+	if len(list) > 0 && strings.HasPrefix(strings.TrimSpace(list[0]), "{") {
+		if err := json.Unmarshal([]byte(list[0]), &a.args); err != nil {
+			return nil, fmt.Errorf("invalid json argument, %s", err)
+		}
+		list = list[1:]
+	}
+
+	// 环境变量
+	for _, def := range argDefs {
+		if v, ok := os.LookupEnv(def.env); ok && v != "" {
+			if err := def.apply(a, v); err != nil {
+				return nil, fmt.Errorf("invalid env %s, %s", def.env, err)
+			}
+		}
+	}
+
+	// 命令行参数
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(output)
+	values := make(map[string]*string, len(argDefs))
+	for _, def := range argDefs {
+		values[def.name] = fs.String(def.name, "", fmt.Sprintf("%s (环境变量 %s)", def.usage, def.env))
+	}
+	fs.BoolVar(&a.printConfig, "print-config", false, "输出生效的配置后退出")
+	if err := fs.Parse(list); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("unknown argument %s", fs.Arg(0))
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, def := range argDefs {
+			if def.name == f.Name && err == nil {
+				if e := def.apply(a, *values[def.name]); e != nil {
+					err = fmt.Errorf("invalid argument --%s, %s", def.name, e)
+				}
+			}
+		}
+	})
+	return a, err
+}
+
+// printSetting 输出生效的配置 密码不输出
+func printSetting(s *Setting, configPath string) {
+	broker := s.Broker
+	if broker.Pwd != "" {
+		broker.Pwd = redactMask
+	}
+	js, _ := json.MarshalIndent(map[string]any{
+		"Module":     s.Module,
+		"Desc":       s.Desc,
+		"Version":    s.Version,
+		"DeviceCode": s.deviceCode,
+		"ConfigPath": configPath,
+		"Broker":     broker,
+		"Meta":       s.meta,
+		"NoticeMeta": s.noticeMeta,
+		"ErrorLog":   s.errorLog,
+		"LogUpload":  s.logUpload,
+		"Limit":      s.limit,
+		"Trace":      s.trace,
+		"FileDir":    s.fileDir,
+	}, "", "  ")
+	fmt.Println(string(js))
+}
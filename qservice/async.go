@@ -0,0 +1,85 @@
+package qservice
+
+import (
+	"context"
+	"github.com/kamioair/quick-utils/qdefine"
+	"sync"
+)
+
+// Future 异步请求的结果
+type Future struct {
+	done chan struct{}
+	resp qdefine.Context
+	err  error
+}
+
+// Done 请求完成时关闭
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait 等待请求完成并返回结果
+func (f *Future) Wait() (qdefine.Context, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// SendRequestAsync 异步发送请求 立即返回，通过Future获取结果
+func (serv *MicroService) SendRequestAsync(ctx context.Context, module, route string, params any, opts ...CallOption) *Future {
+	f := &Future{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		defer errRecover(func(err error) {
+			f.err = err
+		})
+		f.resp, f.err = serv.SendRequestCtx(ctx, module, route, params, opts...)
+	}()
+	return f
+}
+
+// ScatterResult 群发请求中单个模块的结果
+type ScatterResult struct {
+	Module string          // 目标模块
+	Resp   qdefine.Context // 响应 失败时为nil
+	Err    error           // 错误
+}
+
+// Scatter 向多个模块并行发送同一请求 结果顺序与modules一致
+// limit为同时进行的请求数，小于等于0时不限制；总截止时间由ctx控制，
+// ctx结束时仍未发出的请求直接返回超时或取消错误
+func (serv *MicroService) Scatter(ctx context.Context, modules []string, route string, params any, limit int, opts ...CallOption) []ScatterResult {
+	results := make([]ScatterResult, len(modules))
+	if limit <= 0 || limit > len(modules) {
+		limit = len(modules)
+	}
+	sem := make(chan struct{}, limit)
+	wg := sync.WaitGroup{}
+	for i, module := range modules {
+		results[i].Module = module
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctxError(ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(rs *ScatterResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			rs.Resp, rs.Err = serv.SendRequestAsync(ctx, rs.Module, route, params, opts...).Wait()
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// DeviceModules 生成指定设备上的模块名称 用于向多台设备群发请求
+func DeviceModules(module string, codes ...string) []string {
+	list := make([]string, 0, len(codes))
+	for _, code := range codes {
+		list = append(list, module+"."+code)
+	}
+	return list
+}
@@ -0,0 +1,277 @@
+package qservice
+
+import (
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qlog"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// builtinPrefix 内置状态路由的前缀 以此开头的路由名称不能被注册
+const builtinPrefix = "@"
+
+// 内置路由 由MicroService直接处理 不能被注册
+// 状态路由带@前缀，避免与模块自己的Info、Health等路由冲突
+var reservedRoutes = map[string]bool{
+	"Exit":      true,
+	"Reset":     true,
+	"@Info":     true,
+	"@Routes":   true,
+	"@Health":   true,
+	"@Metrics":  true,
+	"@Errors":   true,
+	"@LogLevel": true,
+}
+
+// ModuleInfo 内置路由@Info的响应
+type ModuleInfo struct {
+	Module           string
+	Desc             string
+	Version          string
+	DeviceCode       string
+	ParentDeviceCode string
+	StartTime        string
+	Uptime           float64 // 运行时长 秒
+	InFlight         int     // 处理中的请求和通知
+	Runtime          RuntimeInfo
+}
+
+// RuntimeInfo Go运行时信息
+type RuntimeInfo struct {
+	GoVersion    string
+	NumCPU       int
+	NumGoroutine int
+	HeapAlloc    uint64 // 堆内存 字节
+	Sys          uint64 // 向系统申请的内存 字节
+	NumGC        uint32
+}
+
+// RouteInfo 内置路由@Routes的响应项
+type RouteInfo struct {
+	Name    string
+	Builtin bool    // 是否内置路由
+	Input   *Schema `json:",omitempty"` // 输入结构 原始路由为空
+	Output  *Schema `json:",omitempty"` // 输出结构 原始路由为空
+}
+
+// Schema 数据结构描述
+type Schema struct {
+	Type   string             // string|int|uint|float|bool|date|datetime|time|bytes|object|array|map|any
+	Name   string             `json:",omitempty"` // 类型名称
+	Valid  string             `json:",omitempty"` // 校验规则
+	Fields map[string]*Schema `json:",omitempty"` // object的字段
+	Items  *Schema            `json:",omitempty"` // array和map的元素
+}
+
+// HealthStatus 内置路由@Health的响应
+type HealthStatus struct {
+	Status string                  // UP|DOWN
+	Checks map[string]HealthResult // 各项检查结果
+}
+
+// HealthResult 单项健康检查结果
+type HealthResult struct {
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// HealthCheck 健康检查方法 返回错误表示不健康
+type HealthCheck func() error
+
+type healthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// AddHealthCheck 添加健康检查 内置路由@Health会依次执行
+func (s *Setting) AddHealthCheck(name string, check HealthCheck) *Setting {
+	s.healthChecks = append(s.healthChecks, healthCheck{name: name, check: check})
+	return s
+}
+
+// onBuiltin 处理内置路由 不是内置路由时返回false
+func (serv *MicroService) onBuiltin(route string, ctx qdefine.Context) (any, bool) {
+	switch route {
+	case "@Info":
+		return serv.info(), true
+	case "@Routes":
+		return serv.routes(), true
+	case "@Health":
+		return serv.health(), true
+	case "@Metrics":
+		return serv.metrics.snapshot(), true
+	case "@Errors":
+		return serv.onErrors(ctx), true
+	case "@LogLevel":
+		return serv.onLogLevel(ctx), true
+	}
+	return serv.onFileRoute(route, ctx)
+}
+
+// metricRoute 路由的统计名称 不是内置路由也未在Router中注册时返回unknownRoute
+func (serv *MicroService) metricRoute(route string) string {
+	if reservedRoutes[route] || serv.isFileRoute(route) {
+		return route
+	}
+	if serv.setting.router != nil && serv.setting.router.has(route) {
+		return route
+	}
+	return unknownRoute
+}
+
+// onLogLevel 内置路由@LogLevel 参数Level不为空时修改日志级别 返回当前级别
+func (serv *MicroService) onLogLevel(ctx qdefine.Context) string {
+	if str := ctx.GetStringOr("Level", ""); str != "" {
+		level, err := qlog.ParseLevel(str)
+		if err != nil {
+			panic(ErrBadReq.WithMessage(err.Error()).WithDetail("Key", "Level"))
+		}
+		serv.logger.SetLevel(level)
+	}
+	return serv.logger.Level().String()
+}
+
+func (serv *MicroService) info() ModuleInfo {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+	return ModuleInfo{
+		Module:           serv.setting.Module,
+		Desc:             serv.setting.Desc,
+		Version:          serv.setting.Version,
+		DeviceCode:       serv.setting.deviceCode,
+		ParentDeviceCode: serv.setting.parentDevCode,
+		StartTime:        serv.startTime.Format(reqTimeFormat),
+		Uptime:           time.Since(serv.startTime).Seconds(),
+		InFlight:         serv.inflight.active(),
+		Runtime: RuntimeInfo{
+			GoVersion:    runtime.Version(),
+			NumCPU:       runtime.NumCPU(),
+			NumGoroutine: runtime.NumGoroutine(),
+			HeapAlloc:    mem.HeapAlloc,
+			Sys:          mem.Sys,
+			NumGC:        mem.NumGC,
+		},
+	}
+}
+
+func (serv *MicroService) routes() []RouteInfo {
+	list := make([]RouteInfo, 0)
+	names := make([]string, 0, len(reservedRoutes))
+	for name := range reservedRoutes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if serv.setting.fileDir != "" {
+		names = append(names, fileRoutes...)
+	}
+	for _, name := range names {
+		list = append(list, RouteInfo{Name: name, Builtin: true})
+	}
+	if serv.setting.router == nil {
+		return list
+	}
+	for _, rt := range serv.setting.router.list() {
+		info := RouteInfo{Name: rt.name}
+		if rt.inType != nil {
+			info.Input = schemaOf(rt.inType, map[reflect.Type]bool{})
+		}
+		if rt.outType != nil {
+			info.Output = schemaOf(rt.outType, map[reflect.Type]bool{})
+		}
+		list = append(list, info)
+	}
+	return list
+}
+
+func (serv *MicroService) health() HealthStatus {
+	rs := HealthStatus{Status: "UP", Checks: map[string]HealthResult{}}
+	for _, hc := range serv.setting.healthChecks {
+		r := HealthResult{Status: "UP"}
+		if err := runCheck(hc.check); err != nil {
+			r = HealthResult{Status: "DOWN", Error: err.Error()}
+			rs.Status = "DOWN"
+		}
+		rs.Checks[hc.name] = r
+	}
+	return rs
+}
+
+// runCheck 执行健康检查 panic视为不健康
+func runCheck(check HealthCheck) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Errorf(ErrInternal.Code, "%v", r)
+		}
+	}()
+	return check()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf 生成类型的结构描述 visiting用于避免递归类型死循环
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case dateType:
+		return &Schema{Type: "date"}
+	case dateTimeType:
+		return &Schema{Type: "datetime"}
+	case timeType:
+		return &Schema{Type: "time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "int"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "uint"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "float"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "bytes"}
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return &Schema{Type: "map", Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Name: t.Name()}
+		if visiting[t] {
+			return s
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		s.Fields = map[string]*Schema{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			fs := schemaOf(f.Type, visiting)
+			fs.Valid = f.Tag.Get(validTag)
+			if f.Anonymous && fs.Type == "object" && f.Tag.Get("json") == "" {
+				for k, v := range fs.Fields {
+					s.Fields[k] = v
+				}
+				continue
+			}
+			s.Fields[name] = fs
+		}
+		return s
+	}
+	return &Schema{Type: "any"}
+}
@@ -0,0 +1,208 @@
+package qservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qdefine"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defMaxTimeOut = 60000 // 访问器单次请求的最长等待时间 毫秒
+	maxAbandoned  = 1024  // 已放弃等待的请求上限 访问器在MaxTimeOut内仍为其保留协程和响应通道
+)
+
+// CallOption 单次请求的选项
+type CallOption func(o *callOptions)
+
+type callOptions struct {
+	timeout    time.Duration // 每次尝试的超时时间
+	retry      int           // 超时后的重试次数
+	backoff    time.Duration // 首次重试前的等待时间 之后每次翻倍
+	idempotent bool          // 请求是否幂等 非幂等请求不会重试
+}
+
+// WithTimeout 设置每次尝试的超时时间 默认使用Broker.TimeOut
+// 超过Broker.MaxTimeOut的部分无效
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetry 设置超时后的重试次数和退避时间 只有超时错误会重试
+// 需同时设置WithIdempotent，否则不会重试
+func WithRetry(count int, backoff time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.retry = count
+		o.backoff = backoff
+	}
+}
+
+// WithIdempotent 声明请求是幂等的 重复执行不会产生副作用
+func WithIdempotent() CallOption {
+	return func(o *callOptions) {
+		o.idempotent = true
+	}
+}
+
+// SendRequestCtx 发送请求 ctx取消或到达截止时间时立即返回
+func (serv *MicroService) SendRequestCtx(ctx context.Context, module, route string, params any, opts ...CallOption) (qdefine.Context, error) {
+	o := &callOptions{
+		timeout: time.Duration(serv.setting.Broker.TimeOut) * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// 调用段覆盖全部重试
+	parent, _ := SpanFromContext(ctx)
+	span := serv.tracer.start(SpanClient, route, module, parent)
+	rs, err := serv.sendRequest(ctx, module, route, params, o, span.context())
+	code := easyCon.ERespSuccess
+	var se *Error
+	if errors.As(err, &se) {
+		code = se.Code
+	}
+	span.end(code, err)
+	return rs, err
+}
+
+// sendRequest 按选项发送请求 超时且幂等时重试
+func (serv *MicroService) sendRequest(ctx context.Context, module, route string, params any, o *callOptions, sc SpanContext) (qdefine.Context, error) {
+	backoff := o.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := serv.reqOnce(ctx, module, route, params, o.timeout, sc)
+		if err != nil {
+			return nil, err
+		}
+		if resp.RespCode == easyCon.ERespSuccess {
+			// 返回成功
+			return newControlResp(resp)
+		}
+		if resp.RespCode != easyCon.ERespTimeout || !o.idempotent || attempt >= o.retry {
+			// 返回异常
+			return nil, errorOfResp(resp)
+		}
+		// 退避后重试
+		if backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctxError(ctx.Err())
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// reqOnce 发送一次请求 超时返回超时响应，ctx结束返回ErrCanceled或ErrTimeout
+func (serv *MicroService) reqOnce(ctx context.Context, module, route string, params any, timeout time.Duration, sc SpanContext) (easyCon.PackResp, error) {
+	if err := ctx.Err(); err != nil {
+		return easyCon.PackResp{}, ctxError(err)
+	}
+	if atomic.LoadInt64(&serv.abandoned) >= maxAbandoned {
+		return easyCon.PackResp{}, ErrBusy.WithMessage("too many abandoned requests")
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	// 目标模块
+	md := meta{Timeout: timeout.Milliseconds(), TraceId: sc.TraceId, SpanId: sc.SpanId}
+	params = serv.withMeta(params, md)
+	module = serv.newModuleName(module, serv.setting.deviceCode)
+	if strings.Contains(module, "/") {
+		// 路由请求
+		newParams := map[string]any{}
+		newParams["Module"] = module
+		newParams["Route"] = route
+		newParams["Content"] = params
+		module, route, params = "Route", "Request", serv.withMeta(newParams, md)
+	}
+
+	// 访问器超时后协程自行结束，迟到的响应被丢弃
+	// 放弃等待的请求计入abandoned，超过上限时不再发送新的请求
+	adapter := serv.adapter
+	ch := make(chan easyCon.PackResp, 1)
+	var state int32
+	go func() {
+		ch <- adapter.Req(module, route, params)
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			atomic.AddInt64(&serv.abandoned, -1)
+		}
+	}()
+	abandon := func() {
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			atomic.AddInt64(&serv.abandoned, 1)
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-timer.C:
+		abandon()
+		return easyCon.PackResp{RespCode: easyCon.ERespTimeout}, nil
+	case <-ctx.Done():
+		abandon()
+		return easyCon.PackResp{}, ctxError(ctx.Err())
+	}
+}
+
+// ctxError 将ctx结束的原因转为服务错误 取消返回ErrCanceled，到达截止时间返回ErrTimeout
+func ctxError(err error) *Error {
+	if errors.Is(err, context.Canceled) {
+		return ErrCanceled.WithCause(err)
+	}
+	return ErrTimeout.WithCause(err)
+}
+
+// Call 发送请求并将响应内容解析为Resp 超时和重试与SendRequest一致
+//
+//	user, err := qservice.Call[User](serv, "UserManager", "GetUser", GetUserReq{Id: 1})
+func Call[Resp any](serv *MicroService, module, route string, req any) (Resp, error) {
+	resp, err := serv.SendRequest(module, route, req)
+	return decodeResp[Resp](resp, err, module, route)
+}
+
+// CallCtx 发送请求并将响应内容解析为Resp 超时和重试与SendRequestCtx一致
+func CallCtx[Resp any](ctx context.Context, serv *MicroService, module, route string, req any, opts ...CallOption) (Resp, error) {
+	resp, err := serv.SendRequestCtx(ctx, module, route, req, opts...)
+	return decodeResp[Resp](resp, err, module, route)
+}
+
+// decodeResp 将响应内容解析为Resp 解析失败返回500错误
+func decodeResp[Resp any](resp qdefine.Context, err error, module, route string) (Resp, error) {
+	var out Resp
+	if err != nil {
+		return out, err
+	}
+	if resp.Raw() == nil {
+		return out, nil
+	}
+	out, err = qconvert.ToAnyError[Resp](resp.Raw())
+	if err != nil {
+		return out, ErrInternal.WithMessage(fmt.Sprintf("response of %s.%s decode failed", module, route)).WithCause(err)
+	}
+	return out, nil
+}
+
+// Notify 发送强类型通知 发送失败时返回错误
+func Notify[T any](serv *MicroService, route string, content T) error {
+	return NotifyCtx(context.Background(), serv, route, content)
+}
+
+// NotifyCtx 发送强类型通知 属于ctx中的链路
+func NotifyCtx[T any](ctx context.Context, serv *MicroService, route string, content T) error {
+	if err := serv.sendNotice(ctx, route, content); err != nil {
+		return ErrUnLinked.WithMessage("notice send failed").WithCause(err)
+	}
+	return nil
+}
@@ -1,10 +1,28 @@
 package qservice
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"github.com/kamioair/quick-utils/qconfig"
 	"github.com/kamioair/quick-utils/qio"
+	"github.com/kamioair/quick-utils/qlog"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
+)
+
+// 设备码由15位Crockford Base32字符加1位校验字符组成
+const (
+	codeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	codeBodyLen  = 15
+	// codeFileEnv 指定设备码文件路径的环境变量
+	codeFileEnv = "QF_DEVICE_FILE"
 )
 
 var DeviceCode deviceCode
@@ -12,40 +30,244 @@ var DeviceCode deviceCode
 type deviceCode struct {
 }
 
-// LoadFromFile 从文件中获取设备码
+// Generate 根据本机的machine-id生成设备码 没有machine-id时使用固定硬盘的序列号
+// 同一台机器每次生成的结果相同 不使用网卡MAC，插拔网卡、开关虚拟网卡会改变结果
+func (d *deviceCode) Generate() (string, error) {
+	ids := machineIds()
+	if len(ids) == 0 {
+		return "", errors.New("deviceCode generate failed, no machine identifier found")
+	}
+	sum := sha256.Sum256([]byte(strings.Join(ids, "|")))
+	num := new(big.Int).SetBytes(sum[:])
+	body := make([]byte, codeBodyLen)
+	base := big.NewInt(int64(len(codeAlphabet)))
+	mod := new(big.Int)
+	for i := range body {
+		num.DivMod(num, base, mod)
+		body[i] = codeAlphabet[mod.Int64()]
+	}
+	return string(body) + string(checkChar(string(body))), nil
+}
+
+// Validate 校验设备码的格式和校验位
+func (d *deviceCode) Validate(code string) error {
+	if len(code) != codeBodyLen+1 {
+		return fmt.Errorf("deviceCode %s length must be %d", code, codeBodyLen+1)
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(codeAlphabet, c) {
+			return fmt.Errorf("deviceCode %s contains invalid char %c", code, c)
+		}
+	}
+	if checkChar(code[:codeBodyLen]) != code[codeBodyLen] {
+		return fmt.Errorf("deviceCode %s checksum mismatch", code)
+	}
+	return nil
+}
+
+// LoadOrGenerate 读取设备码 不存在或校验失败时生成并保存
+func (d *deviceCode) LoadOrGenerate() (string, error) {
+	if code, err := d.LoadFromFile(); err == nil {
+		return code, nil
+	}
+	code, err := d.Generate()
+	if err != nil {
+		return "", err
+	}
+	return code, d.SaveToFile(code)
+}
+
+// LoadFromFile 从文件中获取设备码 按CodeFiles的顺序读取第一个存在的文件
+// CodeFiles中的设备码需通过校验；旧版本位置的设备码格式不同，校验失败时记录警告后照常使用
 func (d *deviceCode) LoadFromFile() (string, error) {
-	file := getCodeFile()
-	if qio.PathExists(file) {
-		code, err := qio.ReadAllString(file)
+	for _, file := range d.CodeFiles() {
+		code, err := readCodeFile(file)
 		if err != nil {
 			return "", err
 		}
+		if code == "" {
+			continue
+		}
+		if err = d.Validate(code); err != nil {
+			return "", fmt.Errorf("deviceCode file %s invalid, %w", file, err)
+		}
+		return code, nil
+	}
+	for _, file := range legacyCodeFiles() {
+		code, err := readCodeFile(file)
+		if err != nil || code == "" {
+			continue
+		}
+		if e := d.Validate(code); e != nil {
+			qlog.Warn("deviceCode loaded from legacy file in legacy format", "File", file, "Code", code, "Reason", e)
+		}
 		return code, nil
 	}
 	return "", errors.New("deviceCode file not find")
 }
 
-// SaveToFile 将设备码写入文件
+// SaveToFile 将设备码写入文件 按CodeFiles的顺序写入第一个可写的位置 设备码需通过校验
 func (d *deviceCode) SaveToFile(code string) error {
-	// 写入文件
-	file := getCodeFile()
-	if file == "" {
-		return errors.New("deviceCode file not find")
+	if err := d.Validate(code); err != nil {
+		return err
+	}
+	var errs []error
+	for _, file := range d.CodeFiles() {
+		err := writeFileAtomic(file, []byte(code))
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("deviceCode save failed, %w", errors.Join(errs...))
+}
+
+// CodeFiles 设备码文件的候选位置 按优先级排序
+// 环境变量QF_DEVICE_FILE 或配置device.file 指定时只使用指定的位置
+func (d *deviceCode) CodeFiles() []string {
+	if file := os.Getenv(codeFileEnv); file != "" {
+		return []string{file}
 	}
-	err := qio.WriteString(file, code, false)
+	if file := qconfig.Get("", "device.file", ""); file != "" {
+		return []string{qio.GetFullPath(file)}
+	}
+	files := make([]string, 0)
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			files = append(files, filepath.Join(dir, "qf", "device"))
+		}
+	default:
+		files = append(files, "/etc/qf/device", "/var/lib/qf/device")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".qf", "device"))
+	}
+	return append(files, qio.GetFullPath("./config/device"))
+}
+
+// readCodeFile 读取设备码文件 文件不存在时返回空
+func readCodeFile(file string) (string, error) {
+	if !qio.PathExists(file) {
+		return "", nil
+	}
+	code, err := qio.ReadAllString(file)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return strings.TrimSpace(code), nil
 }
 
-func getCodeFile() string {
-	root := qio.GetCurrentRoot()
+// legacyCodeFiles 旧版本的设备码位置 只读取不写入
+func legacyCodeFiles() []string {
 	switch runtime.GOOS {
 	case "windows":
-		return fmt.Sprintf("%s\\Program Files\\qf\\device", root)
+		return []string{fmt.Sprintf("%s\\Program Files\\qf\\device", qio.GetCurrentRoot())}
 	case "linux":
-		return "/dev/qf/device"
+		return []string{"/dev/qf/device"}
+	}
+	return nil
+}
+
+// writeFileAtomic 先写入临时文件再重命名 避免写入中断导致文件损坏
+func writeFileAtomic(file string, data []byte) error {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// checkChar 计算校验字符 使用Luhn mod N算法
+func checkChar(body string) byte {
+	n := len(codeAlphabet)
+	factor, sum := 2, 0
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(codeAlphabet, body[i])
+		sum += addend/n + addend%n
+		factor = 3 - factor
+	}
+	return codeAlphabet[(n-sum%n)%n]
+}
+
+// machineIds 本机的稳定标识 有machine-id时只使用machine-id，否则使用固定硬盘的序列号
+func machineIds() []string {
+	if id := machineId(); id != "" {
+		return []string{"machine:" + id}
+	}
+	ids := make([]string, 0)
+	for _, serial := range diskSerials() {
+		ids = append(ids, "disk:"+serial)
+	}
+	return ids
+}
+
+func machineId() string {
+	switch runtime.GOOS {
+	case "windows":
+		out := command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid")
+		if m := regexp.MustCompile(`MachineGuid\s+REG_SZ\s+(\S+)`).FindStringSubmatch(out); m != nil {
+			return m[1]
+		}
+	case "darwin":
+		out := command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice")
+		if m := regexp.MustCompile(`"IOPlatformUUID" = "(\S+)"`).FindStringSubmatch(out); m != nil {
+			return m[1]
+		}
+	default:
+		for _, file := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if str, err := os.ReadFile(file); err == nil && strings.TrimSpace(string(str)) != "" {
+				return strings.TrimSpace(string(str))
+			}
+		}
 	}
 	return ""
 }
+
+// diskSerials 固定硬盘的序列号 排除U盘等可移动硬盘
+func diskSerials() []string {
+	list := make([]string, 0)
+	switch runtime.GOOS {
+	case "windows":
+		out := command("wmic", "diskdrive", "where", "MediaType='Fixed hard disk media'", "get", "SerialNumber")
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != "" && line != "SerialNumber" {
+				list = append(list, line)
+			}
+		}
+	case "linux":
+		dirs, _ := filepath.Glob("/sys/block/*")
+		for _, dir := range dirs {
+			if removable, err := os.ReadFile(filepath.Join(dir, "removable")); err != nil || strings.TrimSpace(string(removable)) != "0" {
+				continue
+			}
+			if str, err := os.ReadFile(filepath.Join(dir, "device", "serial")); err == nil && strings.TrimSpace(string(str)) != "" {
+				list = append(list, strings.TrimSpace(string(str)))
+			}
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func command(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
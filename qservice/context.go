@@ -1,21 +1,36 @@
 package qservice
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gobeam/stringy"
+	"github.com/kamioair/quick-utils/qconvert"
 	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qlog"
 	easyCon "github.com/qiu-tec/easy-con.golang"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
-type context struct {
+type control struct {
 	reqPack    easyCon.PackReq
 	respPack   easyCon.PackResp
 	noticePack easyCon.PackNotice
 	values     *values
+	source     string
+	id         uint64
+	route      string
+	sendTime   time.Time
+	deadline   time.Time
+	ctx        context.Context
+	cancel     context.CancelFunc
+	traceId    string
+	spanId     string
+	parentSpan string       // 调用方的调用段Id
+	logger     *qlog.Logger // 附加了链路标识的模块日志
 }
 
 type values struct {
@@ -24,23 +39,61 @@ type values struct {
 	OutputValue interface{}
 }
 
-func newControlReq(pack easyCon.PackReq) (*context, error) {
-	ctx := &context{
-		reqPack: pack,
+// newControlReq 创建请求上下文
+// 截止时间为收到请求的时间加上调用方的等待时长，调用方未携带时使用defTimeout
+func newControlReq(pack easyCon.PackReq, defTimeout time.Duration) (*control, error) {
+	ctx := &control{
+		reqPack:  pack,
+		source:   pack.From,
+		id:       pack.Id,
+		route:    pack.Route,
+		sendTime: parseReqTime(pack.ReqTime),
 		values: &values{
 			InputMaps: make([]map[string]interface{}, 0),
 		},
 	}
-	err := setData(ctx, pack.Content)
+	md, content, ok := unwrapMeta(pack.Content)
+	timeout := defTimeout
+	if ok && md.Timeout > 0 {
+		timeout = time.Duration(md.Timeout) * time.Millisecond
+	}
+	err := setData(ctx, content)
 	if err != nil {
 		return nil, err
 	}
+	ctx.setSpan(md)
+	if timeout > 0 {
+		ctx.deadline = time.Now().Add(timeout)
+		ctx.ctx, ctx.cancel = context.WithDeadline(ctx.ctx, ctx.deadline)
+	}
 	return ctx, nil
 }
 
-func newControlResp(pack easyCon.PackResp) (*context, error) {
-	ctx := &context{
+// NewContext 根据请求包创建请求上下文 用于测试处理方法或自定义访问器
+// 请求内容经过json序列化，与经过MQTT传输的效果一致；timeout为调用方未携带元数据时的超时时间
+// 处理完成后调用release释放上下文
+func NewContext(pack easyCon.PackReq, timeout time.Duration) (ctx qdefine.Context, release func(), err error) {
+	pack.PType = easyCon.EPTypeReq
+	if pack.ReqTime == "" {
+		pack.ReqTime = time.Now().Format(reqTimeFormat)
+	}
+	if err = RoundTrip(&pack); err != nil {
+		return nil, nil, err
+	}
+	c, err := newControlReq(pack, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, c.release, nil
+}
+
+func newControlResp(pack easyCon.PackResp) (*control, error) {
+	ctx := &control{
 		respPack: pack,
+		source:   pack.To,
+		id:       pack.Id,
+		route:    pack.Route,
+		sendTime: parseReqTime(pack.RespTime),
 		values: &values{
 			InputMaps: make([]map[string]interface{}, 0),
 		},
@@ -52,21 +105,52 @@ func newControlResp(pack easyCon.PackResp) (*context, error) {
 	return ctx, nil
 }
 
-func newControlNotice(pack easyCon.PackNotice) (*context, error) {
-	ctx := &context{
+func newControlNotice(pack easyCon.PackNotice) (*control, error) {
+	ctx := &control{
 		noticePack: pack,
+		source:     pack.From,
+		id:         pack.Id,
+		route:      pack.Route,
 		values: &values{
 			InputMaps: make([]map[string]interface{}, 0),
 		},
 	}
-	err := setData(ctx, pack.Content)
+	md, content, _ := unwrapMeta(pack.Content)
+	err := setData(ctx, content)
 	if err != nil {
 		return nil, err
 	}
+	ctx.setSpan(md)
 	return ctx, nil
 }
 
-func setData(ctx *context, data any) error {
+// setSpan 沿用调用方的链路Id并生成当前调用段Id 调用方未携带时开始新的链路
+func (c *control) setSpan(md meta) {
+	c.traceId = md.TraceId
+	if c.traceId == "" {
+		c.traceId = newTraceId()
+	}
+	c.spanId = newSpanId()
+	c.parentSpan = md.SpanId
+	c.ctx = ContextWithSpan(context.Background(), c.span())
+}
+
+// span 当前处理的链路标识 上下文未创建时返回空
+func (c *control) span() SpanContext {
+	if c == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceId: c.traceId, SpanId: c.spanId}
+}
+
+// release 释放上下文 取消截止时间的计时
+func (c *control) release() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func setData(ctx *control, data any) error {
 	if data != nil {
 		var content []byte
 		switch data.(type) {
@@ -88,7 +172,7 @@ func setData(ctx *context, data any) error {
 	return nil
 }
 
-func (c *context) GetString(key string) string {
+func (c *control) GetString(key string) string {
 	value := c.values.getValue(key)
 	// 返回
 	if value == nil {
@@ -109,31 +193,31 @@ func (c *context) GetString(key string) string {
 	return str
 }
 
-func (c *context) GetInt(key string) int {
-	num, err := strconv.Atoi(c.GetString(key))
+func (c *control) GetInt(key string) int {
+	num, err := c.TryGetInt(key)
 	if err != nil {
 		panic(err)
 	}
 	return num
 }
 
-func (c *context) GetUInt(key string) uint64 {
-	num, err := strconv.ParseUint(c.GetString(key), 10, 64)
+func (c *control) GetUInt(key string) uint64 {
+	num, err := c.TryGetUInt(key)
 	if err != nil {
 		panic(err)
 	}
 	return num
 }
 
-func (c *context) GetByte(key string) byte {
-	num, err := strconv.ParseInt(c.GetString(key), 10, 8)
+func (c *control) GetByte(key string) byte {
+	num, err := c.TryGetByte(key)
 	if err != nil {
 		panic(err)
 	}
-	return byte(num)
+	return num
 }
 
-func (c *context) GetBool(key string) bool {
+func (c *control) GetBool(key string) bool {
 	value := strings.ToLower(c.GetString(key))
 	if value == "true" || value == "1" {
 		return true
@@ -141,40 +225,264 @@ func (c *context) GetBool(key string) bool {
 	return false
 }
 
-func (c *context) GetDate(key string) qdefine.Date {
+func (c *control) GetFloat(key string) float64 {
+	num, err := c.TryGetFloat(key)
+	if err != nil {
+		panic(err)
+	}
+	return num
+}
+
+func (c *control) GetDate(key string) qdefine.Date {
+	date, err := c.TryGetDate(key)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
+
+func (c *control) GetDateTime(key string) qdefine.DateTime {
+	date, err := c.TryGetDateTime(key)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
+
+func (c *control) GetDuration(key string) time.Duration {
+	d, err := c.TryGetDuration(key)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// GetSlice 获取数组 不存在或不是数组时返回nil
+func (c *control) GetSlice(key string) []any {
+	if list, ok := c.values.getValue(key).([]any); ok {
+		return list
+	}
+	return nil
+}
+
+// GetMap 获取对象 不存在或不是对象时返回nil
+func (c *control) GetMap(key string) map[string]any {
+	if m, ok := c.values.getValue(key).(map[string]any); ok {
+		return m
+	}
+	return nil
+}
+
+// Has 判断键是否存在
+func (c *control) Has(key string) bool {
+	_, ok := c.values.lookup(key)
+	return ok
+}
+
+func (c *control) TryGetString(key string) (string, error) {
+	if !c.Has(key) {
+		return "", missingError(key)
+	}
+	return c.GetString(key), nil
+}
+
+func (c *control) TryGetInt(key string) (int, error) {
+	str, err := c.TryGetString(key)
+	if err != nil {
+		return 0, err
+	}
+	num, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, invalidError(key, err)
+	}
+	return num, nil
+}
+
+func (c *control) TryGetUInt(key string) (uint64, error) {
+	str, err := c.TryGetString(key)
+	if err != nil {
+		return 0, err
+	}
+	num, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, invalidError(key, err)
+	}
+	return num, nil
+}
+
+func (c *control) TryGetByte(key string) (byte, error) {
+	str, err := c.TryGetString(key)
+	if err != nil {
+		return 0, err
+	}
+	num, err := strconv.ParseUint(str, 10, 8)
+	if err != nil {
+		return 0, invalidError(key, err)
+	}
+	return byte(num), nil
+}
+
+func (c *control) TryGetBool(key string) (bool, error) {
+	str, err := c.TryGetString(key)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(str) {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return false, invalidError(key, fmt.Errorf("%s is not a bool", str))
+}
+
+func (c *control) TryGetFloat(key string) (float64, error) {
+	str, err := c.TryGetString(key)
+	if err != nil {
+		return 0, err
+	}
+	num, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, invalidError(key, err)
+	}
+	return num, nil
+}
+
+func (c *control) TryGetDate(key string) (qdefine.Date, error) {
 	model := struct {
 		Time qdefine.Date
 	}{}
-	js := fmt.Sprintf("{\"Time\":\"%s\"}", c.GetString(key))
-	err := json.Unmarshal([]byte(js), &model)
-	if err != nil {
-		panic(err)
+	if err := c.unmarshalTime(key, &model); err != nil {
+		return 0, err
 	}
-	return model.Time
+	return model.Time, nil
 }
 
-func (c *context) GetDateTime(key string) qdefine.DateTime {
+func (c *control) TryGetDateTime(key string) (qdefine.DateTime, error) {
 	model := struct {
 		Time qdefine.DateTime
 	}{}
-	js := fmt.Sprintf("{\"Time\":\"%s\"}", c.GetString(key))
-	err := json.Unmarshal([]byte(js), &model)
+	if err := c.unmarshalTime(key, &model); err != nil {
+		return 0, err
+	}
+	return model.Time, nil
+}
+
+// TryGetDuration 获取时长 支持 1m30s 这样的字符串，纯数字按毫秒处理
+func (c *control) TryGetDuration(key string) (time.Duration, error) {
+	str, err := c.TryGetString(key)
 	if err != nil {
-		panic(err)
+		return 0, err
+	}
+	if num, e := strconv.ParseFloat(str, 64); e == nil {
+		return time.Duration(num * float64(time.Millisecond)), nil
 	}
-	return model.Time
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, invalidError(key, err)
+	}
+	return d, nil
 }
 
-func (c *context) GetFiles(key string) []qdefine.File {
-	value := c.values.getValue(key)
-	// 返回
-	if files, ok := value.([]qdefine.File); ok {
-		return files
+func (c *control) GetStringOr(key string, def string) string {
+	if v, err := c.TryGetString(key); err == nil {
+		return v
+	}
+	return def
+}
+
+func (c *control) GetIntOr(key string, def int) int {
+	if v, err := c.TryGetInt(key); err == nil {
+		return v
+	}
+	return def
+}
+
+func (c *control) GetUIntOr(key string, def uint64) uint64 {
+	if v, err := c.TryGetUInt(key); err == nil {
+		return v
+	}
+	return def
+}
+
+func (c *control) GetBoolOr(key string, def bool) bool {
+	if v, err := c.TryGetBool(key); err == nil {
+		return v
+	}
+	return def
+}
+
+func (c *control) GetFloatOr(key string, def float64) float64 {
+	if v, err := c.TryGetFloat(key); err == nil {
+		return v
+	}
+	return def
+}
+
+func (c *control) GetDurationOr(key string, def time.Duration) time.Duration {
+	if v, err := c.TryGetDuration(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// unmarshalTime 通过日期类型的json反转实现解析
+func (c *control) unmarshalTime(key string, model any) error {
+	str, err := c.TryGetString(key)
+	if err != nil {
+		return err
+	}
+	js, _ := json.Marshal(map[string]string{"Time": str})
+	if err = json.Unmarshal(js, model); err != nil {
+		return invalidError(key, err)
 	}
 	return nil
 }
 
-func (c *context) GetStruct(refStruct any) {
+func missingError(key string) error {
+	return ErrBadReq.WithMessage(fmt.Sprintf("request param %s is required", key)).WithDetail("Key", key)
+}
+
+func invalidError(key string, err error) error {
+	return ErrBadReq.WithMessage(fmt.Sprintf("request param %s is invalid, %s", key, err)).WithDetail("Key", key)
+}
+
+// GetFiles 获取文件列表 值可以是文件数组或单个文件，Data为base64字符串
+// 不存在时返回nil，解析失败或校验失败时抛出异常
+func (c *control) GetFiles(key string) []qdefine.File {
+	value := c.values.getValue(key)
+	var files []qdefine.File
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []qdefine.File:
+		files = v
+	case map[string]any:
+		file, err := qconvert.ToAnyError[qdefine.File](v)
+		if err != nil {
+			panic(invalidError(key, err))
+		}
+		files = []qdefine.File{file}
+	default:
+		list, err := qconvert.ToAnyError[[]qdefine.File](v)
+		if err != nil {
+			panic(invalidError(key, err))
+		}
+		files = list
+	}
+	for i := range files {
+		// 旧版本调用方不发送Size
+		if files[i].Size == 0 {
+			files[i].Size = int64(len(files[i].Data))
+		}
+		if err := files[i].Verify(); err != nil {
+			panic(invalidError(key, err))
+		}
+	}
+	return files
+}
+
+func (c *control) GetStruct(refStruct any) {
 	var val any
 
 	t := reflect.ValueOf(refStruct)
@@ -182,9 +490,14 @@ func (c *context) GetStruct(refStruct any) {
 		t = t.Elem()
 	}
 	if t.Kind() == reflect.Slice {
-		val = c.values.InputMaps
+		// 输入为数组时按原始值解析，单个对象按只有一个元素的数组解析
+		if _, ok := c.values.InputRaw.([]any); ok {
+			val = c.values.InputRaw
+		} else {
+			val = c.values.InputMaps
+		}
 	} else {
-		val = c.values.getValue("")
+		val = c.values.InputRaw
 	}
 
 	// 先转为json
@@ -195,14 +508,72 @@ func (c *context) GetStruct(refStruct any) {
 	// 再反转
 	err = json.Unmarshal(js, refStruct)
 	if err != nil {
+		panic(ErrBadReq.WithMessage("request bad, " + err.Error()))
+	}
+	// 按valid标签校验
+	if err = Validate(refStruct); err != nil {
 		panic(err)
 	}
 }
 
-func (c *context) Raw() any {
+func (c *control) Raw() any {
 	return c.values.InputRaw
 }
 
+func (c *control) Source() string {
+	return c.source
+}
+
+func (c *control) RequestId() uint64 {
+	return c.id
+}
+
+func (c *control) Route() string {
+	return c.route
+}
+
+func (c *control) SendTime() time.Time {
+	return c.sendTime
+}
+
+func (c *control) Timeout() time.Duration {
+	if c.deadline.IsZero() {
+		return 0
+	}
+	if d := time.Until(c.deadline); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (c *control) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *control) TraceId() string {
+	return c.traceId
+}
+
+// Logger 附加了链路标识的日志 未绑定模块日志时使用默认日志
+func (c *control) Logger() qdefine.Logger {
+	if c.logger == nil {
+		c.logger = qlog.Default().With("TraceId", c.traceId, "SpanId", c.spanId)
+	}
+	return c.logger
+}
+
+// bindLogger 绑定模块日志 处理方法中的日志都携带链路标识
+func (c *control) bindLogger(logger *qlog.Logger) {
+	c.logger = logger.With("TraceId", c.traceId, "SpanId", c.spanId)
+}
+
+func (c *control) SpanId() string {
+	return c.spanId
+}
+
 func (d *values) load(content []byte) error {
 	var obj interface{}
 	err := json.Unmarshal(content, &obj)
@@ -210,14 +581,20 @@ func (d *values) load(content []byte) error {
 		return err
 	}
 	maps := make([]map[string]interface{}, 0)
-	kind := reflect.TypeOf(obj).Kind()
-	if kind == reflect.Slice {
-		for _, o := range obj.([]interface{}) {
-			maps = append(maps, o.(map[string]interface{}))
+	switch v := obj.(type) {
+	case []interface{}:
+		// 对象数组按对象拆分，包含非对象元素时按原始值保存
+		for _, o := range v {
+			m, ok := o.(map[string]interface{})
+			if !ok {
+				maps = []map[string]interface{}{{"": obj}}
+				break
+			}
+			maps = append(maps, m)
 		}
-	} else if kind == reflect.Map || kind == reflect.Struct {
-		maps = append(maps, obj.(map[string]interface{}))
-	} else {
+	case map[string]interface{}:
+		maps = append(maps, v)
+	default:
 		maps = append(maps, map[string]interface{}{"": obj})
 	}
 	d.InputRaw = obj
@@ -226,22 +603,68 @@ func (d *values) load(content []byte) error {
 }
 
 func (d *values) getValue(key string) interface{} {
+	value, _ := d.lookup(key)
+	return value
+}
+
+// lookup 查找键对应的值 先按顶级键查找，找不到时再按多级路径查找
+func (d *values) lookup(key string) (interface{}, bool) {
 	if len(d.InputMaps) == 0 {
-		return nil
+		return nil, false
 	}
-	var value interface{}
-	if v, ok := d.InputMaps[0][key]; ok {
-		// 如果存在
-		value = v
-	} else {
-		str := stringy.New(key).CamelCase().ToLower()
-		// 如果不存在，尝试查找
-		for k, v := range d.InputMaps[0] {
-			if str == stringy.New(k).CamelCase().ToLower() {
-				value = v
-				break
+	if value, ok := findKey(d.InputMaps[0], key); ok {
+		return value, true
+	}
+	if !strings.ContainsAny(key, ".[") {
+		return nil, false
+	}
+	// 多级路径 例如 Items[2].Name
+	var value interface{} = d.InputRaw
+	for _, seg := range splitPath(key) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := findKey(v, seg)
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
 			}
+			value = v[i]
+		default:
+			return nil, false
 		}
 	}
-	return value
+	return value, true
+}
+
+// findKey 在字典中查找键 不区分大小写和命名风格
+func findKey(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		// 如果存在
+		return v, true
+	}
+	str := stringy.New(key).CamelCase().ToLower()
+	// 如果不存在，尝试查找
+	for k, v := range m {
+		if str == stringy.New(k).CamelCase().ToLower() {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// splitPath 拆分路径 Items[2].Name 拆分为 Items 2 Name
+func splitPath(key string) []string {
+	key = strings.NewReplacer("[", ".", "]", "").Replace(key)
+	segs := make([]string, 0)
+	for _, seg := range strings.Split(key, ".") {
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
 }
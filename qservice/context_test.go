@@ -0,0 +1,78 @@
+package qservice
+
+import (
+	"errors"
+	"testing"
+
+	easyCon "github.com/qiu-tec/easy-con.golang"
+)
+
+type addUserReq struct {
+	Name  string  `valid:"required,len=2..20"`
+	Age   int     `valid:"min=1,max=150"`
+	Sex   string  `valid:"enum=Male|Female"`
+	Phone *string `valid:"regex=^1[0-9]{10}$"`
+}
+
+// getStruct 用content创建请求上下文并解析为addUserReq 返回解析时的错误
+func getStruct(t *testing.T, content any) (req addUserReq, err error) {
+	t.Helper()
+	ctx, e := newControlReq(easyCon.PackReq{Route: "AddUser", Content: content}, 0)
+	if e != nil {
+		t.Fatalf("newControlReq %s", e)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+	ctx.GetStruct(&req)
+	return req, nil
+}
+
+// fieldRules 校验错误中未通过的字段和第一个未通过的规则
+func fieldRules(t *testing.T, err error) map[string]string {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) || e.Code != easyCon.ERespBadReq {
+		t.Fatalf("error %v, want 400", err)
+	}
+	rules := make(map[string]string)
+	for _, f := range e.Details["Fields"].([]FieldError) {
+		if _, ok := rules[f.Field]; !ok {
+			rules[f.Field] = f.Rule
+		}
+	}
+	return rules
+}
+
+func TestGetStructObject(t *testing.T) {
+	req, err := getStruct(t, map[string]any{"Name": "admin", "Age": 3, "Sex": "Male", "Phone": "13800000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Name != "admin" || req.Age != 3 || req.Sex != "Male" || req.Phone == nil || *req.Phone != "13800000000" {
+		t.Fatalf("request %+v", req)
+	}
+
+	_, err = getStruct(t, map[string]any{"Name": "a", "Age": 200, "Sex": "Male"})
+	rules := fieldRules(t, err)
+	if len(rules) != 2 || rules["Name"] != "len=2..20" || rules["Age"] != "max=150" {
+		t.Fatalf("rules %v", rules)
+	}
+}
+
+func TestValidateZeroValue(t *testing.T) {
+	// 零值同样校验min、enum和regex，nil指针只校验required
+	_, err := getStruct(t, map[string]any{"Name": "admin", "Age": 0, "Sex": "", "Phone": ""})
+	rules := fieldRules(t, err)
+	if len(rules) != 3 || rules["Age"] != "min=1" || rules["Sex"] != "enum=Male|Female" || rules["Phone"] != "regex=^1[0-9]{10}$" {
+		t.Fatalf("rules %v", rules)
+	}
+
+	_, err = getStruct(t, map[string]any{})
+	rules = fieldRules(t, err)
+	if _, ok := rules["Phone"]; ok || rules["Name"] != "required" {
+		t.Fatalf("rules %v", rules)
+	}
+}
@@ -1,12 +1,15 @@
 package qservice
 
 import (
-	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/kamioair/quick-utils/qconfig"
 	"github.com/kamioair/quick-utils/qdefine"
 	"github.com/kamioair/quick-utils/qio"
 	"os"
 	"strings"
+	"time"
 )
 
 // Setting 模块配置
@@ -19,8 +22,21 @@ type Setting struct {
 	onReqHandler    qdefine.ReqHandler    // 请求回调
 	onNoticeHandler qdefine.NoticeHandler // 通知回调
 	onStateHandler  qdefine.StateHandler  // 状态回调
+	router          *Router               // 路由注册表
+	middlewares     middlewares           // 中间件
 	deviceCode      string                // 设备码
 	parentDevCode   string                // 父级设备码
+	meta            bool                  // 请求是否携带元数据
+	noticeMeta      bool                  // 通知是否携带元数据
+	adapterFactory  AdapterFactory        // 访问器工厂
+	healthChecks    []healthCheck         // 健康检查
+	errorLog        ErrorLogConfig        // 错误日志配置
+	logUpload       bool                  // 日志是否通过SendLog上传
+	shutdownHooks   []ShutdownHook        // 停止钩子
+	drainTimeout    time.Duration         // 停止时的最长等待时间
+	limit           LimitConfig           // 请求并发限制
+	trace           TraceConfig           // 调用段导出配置
+	fileDir         string                // 文件传输的根目录 为空时不开启
 }
 
 // NewSetting 创建模块配置
@@ -31,31 +47,33 @@ func NewSetting(moduleName, moduleDesc, version string) *Setting {
 		panic(err)
 	}
 
+	// 启动参数
+	start, err := parseArgs(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	// 默认值
 	var broker *qdefine.BrokerConfig = nil
 	configPath := "./config/config.yaml"
 	module := moduleName
-	devCode := ""
 	// 根据传参更新配置
-	if len(os.Args) > 1 {
-		args := args{}
-		err = json.Unmarshal([]byte(os.Args[1]), &args)
-		if err != nil {
-			panic(err)
-		}
-		if args.Module != "" {
-			module = args.Module
-		}
-		if args.MqConfig != nil {
-			broker = args.MqConfig
-		}
-		if args.ConfigPath != "" {
-			configPath = args.ConfigPath
-		}
-		devCode = args.DeviceCode
-		if devCode != "" {
-			module = module + "." + devCode
-		}
+	if start.Module != "" {
+		module = start.Module
+	}
+	if start.MqConfig != nil {
+		broker = start.MqConfig
+	}
+	if start.ConfigPath != "" {
+		configPath = start.ConfigPath
+	}
+	devCode := start.DeviceCode
+	if devCode != "" {
+		module = module + "." + devCode
 	}
 	qconfig.ChangeFilePath(configPath)
 	if broker == nil {
@@ -68,14 +86,34 @@ func NewSetting(moduleName, moduleDesc, version string) *Setting {
 			Retry:   qconfig.Get(module, "mqtt.retry", 3),
 		}
 	}
+	if broker.MaxTimeOut <= 0 {
+		broker.MaxTimeOut = qconfig.Get(module, "mqtt.maxTimeOut", defMaxTimeOut)
+	}
+	// 环境变量和命令行参数覆盖主服务配置
+	for _, apply := range start.broker {
+		apply(broker)
+	}
 	// 返回配置
-	return &Setting{
-		Module:     module,
-		Desc:       moduleDesc,
-		Version:    version,
-		Broker:     *broker,
-		deviceCode: devCode,
+	setting := &Setting{
+		Module:       module,
+		Desc:         moduleDesc,
+		Version:      version,
+		Broker:       *broker,
+		deviceCode:   devCode,
+		meta:         qconfig.Get(module, "mqtt.meta", false),
+		noticeMeta:   qconfig.Get(module, "mqtt.noticeMeta", false),
+		errorLog:     loadErrorLogConfig(module),
+		logUpload:    qconfig.Get(module, "log.upload", false),
+		drainTimeout: loadDrainTimeout(module),
+		limit:        loadLimitConfig(module),
+		trace:        loadTraceConfig(module),
+		fileDir:      loadFileDir(module),
 	}
+	if start.printConfig {
+		printSetting(setting, configPath)
+		os.Exit(0)
+	}
+	return setting
 }
 
 func (s *Setting) BindInitFunc(onInitHandler qdefine.InitHandler) *Setting {
@@ -88,6 +126,15 @@ func (s *Setting) BindReqFunc(onReqHandler qdefine.ReqHandler) *Setting {
 	return s
 }
 
+// BindRouter 绑定路由注册表 会替换BindReqFunc绑定的请求回调
+// 原请求回调可通过Router.Fallback继续使用
+// 路由注册表中已注册的通知优先处理，其余通知仍交给BindNoticeFunc绑定的回调
+func (s *Setting) BindRouter(router *Router) *Setting {
+	s.router = router
+	s.onReqHandler = router.OnReq
+	return s
+}
+
 func (s *Setting) BindNoticeFunc(onNoticeHandler qdefine.NoticeHandler) *Setting {
 	s.onNoticeHandler = onNoticeHandler
 	return s
@@ -98,6 +145,26 @@ func (s *Setting) BindStateFunc(onStateHandler qdefine.StateHandler) *Setting {
 	return s
 }
 
+// EnableMeta 设置请求是否携带元数据（调用方超时时间等） 默认关闭
+// 旧版本模块收到的是包装后的请求内容，所有对接模块升级后再开启
+func (s *Setting) EnableMeta(enable bool) *Setting {
+	s.meta = enable
+	return s
+}
+
+// EnableNoticeMeta 设置通知是否携带元数据（链路标识） 默认关闭
+// 所有订阅该通知的模块升级后再开启
+func (s *Setting) EnableNoticeMeta(enable bool) *Setting {
+	s.noticeMeta = enable
+	return s
+}
+
+// SetAdapterFactory 设置访问器工厂 例如使用MemoryBus在单进程内运行多个模块
+func (s *Setting) SetAdapterFactory(factory AdapterFactory) *Setting {
+	s.adapterFactory = factory
+	return s
+}
+
 func (s *Setting) SetDeviceCode(code string) *Setting {
 	s.deviceCode = code
 	sp := strings.Split(s.Module, ".")
@@ -109,14 +176,3 @@ func (s *Setting) SetDeviceCode(code string) *Setting {
 	s.Module = strings.Trim(s.Module, ".")
 	return s
 }
-
-type args struct {
-	Module     string
-	DeviceCode string
-	ConfigPath string
-	MqConfig   *qdefine.BrokerConfig
-}
-
-func writeErrLog(tp string, err string) {
-
-}
@@ -2,63 +2,75 @@ package qservice
 
 import (
 	"fmt"
-	"github.com/fatih/color"
 	"github.com/kamioair/quick-utils/qconvert"
-	"github.com/kamioair/quick-utils/qio"
+	"github.com/kamioair/quick-utils/qlog"
+	easyCon "github.com/qiu-tec/easy-con.golang"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
-	"time"
 )
 
 // Recover
 //
 //	@Description: Panic的异常收集
-func errRecover(after func(err string)) {
+//	              抛出的非500服务错误（如参数校验失败）直接交给after，不作为异常记录
+//	              其余异常输出到控制台，并以panicError交给after写入错误日志
+func errRecover(after func(err error)) {
 	if r := recover(); r != nil {
+		if se, ok := r.(*Error); ok && se.Code != easyCon.ERespError {
+			if after != nil {
+				after(se)
+			}
+			return
+		}
 		// 获取异常
 		var buf [4096]byte
 		n := runtime.Stack(buf[:], false)
 		stackInfo := string(buf[:n])
 
-		// 输出异常
-		log := ""
-		fmt.Println("")
-		nowTime := qconvert.DateTime.ToString(time.Now(), "yyyy-MM-dd HH:mm:ss")
-		color.New(color.FgWhite).PrintfFunc()(nowTime)
-		log += nowTime
-		color.New(color.FgRed, color.Bold).PrintfFunc()(" [ERROR] %s", r)
-		log += fmt.Sprintf(" [ERROR] %s\n", r)
-		fmt.Println("")
+		// 输出异常 只输出panic所在位置，完整调用栈写入错误日志
+		errStr := ""
 		lines := strings.Split(stackInfo, "\n")
 		for i := 0; i < len(lines); i++ {
 			line := strings.Replace(lines[i], "\t", "", -1)
 			if strings.HasPrefix(line, "panic") {
-				errStr := ""
 				if i+3 < len(lines) {
 					errStr += formatStack("curr", lines[i+2], lines[i+3])
 				}
 				if i+5 < len(lines) {
 					errStr += formatStack("upper", lines[i+4], lines[i+5])
 				}
-				color.New(color.FgMagenta).PrintfFunc()("%s\n", errStr)
 			}
-			log += fmt.Sprintf("%s\n", lines[i])
 		}
-		// 写入日志
-		logFile := fmt.Sprintf("%s/%s_Error.log", "./log", qconvert.DateTime.ToString(time.Now(), "yyyy-MM-dd"))
-		logFile = qio.GetFullPath(logFile)
-		log += "----------------------------------------------------------------------------------------------\n\n"
-		_ = qio.WriteString(logFile, log, true)
+		qlog.Error("panic recovered", "Error", r, "At", "\n"+strings.TrimRight(errStr, "\n"))
 
 		// 执行外部方法
 		if after != nil {
-			after(fmt.Sprintf("%s", r))
+			after(&panicError{value: r, stack: stackInfo})
 		}
 	}
 }
 
+// panicError 处理方法中的panic 携带调用栈
+type panicError struct {
+	value any
+	stack string
+}
+
+func (e *panicError) Error() string {
+	if err, ok := e.value.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprintf("%v", e.value)
+}
+
+// Unwrap panic的值是error时返回该error 便于errors.As取出服务错误
+func (e *panicError) Unwrap() error {
+	err, _ := e.value.(error)
+	return err
+}
+
 func formatStack(flag, name string, row string) string {
 	sp := strings.Split(strings.Replace(row, "\t", "", -1), "+")
 	funcName := filepath.Base(name)
@@ -68,3 +80,131 @@ func formatStack(flag, name string, row string) string {
 	}
 	return fmt.Sprintf("   %s\n      %s\n", funcName, sp[0])
 }
+
+// Error 服务错误 携带响应码 可通过errors.Is按响应码比较
+type Error struct {
+	Code    easyCon.EResp  // 响应码
+	Message string         // 错误信息
+	Details map[string]any // 机器可读的详细信息
+	Cause   error          // 原始错误 不会发送给调用方
+}
+
+// easyCon之外的响应码
+const (
+	ERespBusy        easyCon.EResp = 429 // 超过并发限制 与超时区分，重试选项不会重试
+	ERespCanceled    easyCon.EResp = 499 // 调用方取消请求 只在调用方使用，不会发送给对方
+	ERespUnavailable easyCon.EResp = 503 // 服务正在停止 与未连接区分，调用方可以稍后重试
+)
+
+var (
+	ErrUnLinked     = NewError(easyCon.ERespUnLinked, "request unlinked")
+	ErrBadReq       = NewError(easyCon.ERespBadReq, "request bad")
+	ErrForbidden    = NewError(easyCon.ERespForbidden, "request forbidden")
+	ErrRouteNotFind = NewError(easyCon.ERespRouteNotFind, "request route not find")
+	ErrTimeout      = NewError(easyCon.ERespTimeout, "request timeout")
+	ErrInternal     = NewError(easyCon.ERespError, "request error")
+	ErrShutdown     = NewError(ERespUnavailable, "service shutting down")
+	ErrBusy         = NewError(ERespBusy, "service busy")
+	ErrCanceled     = NewError(ERespCanceled, "request canceled")
+)
+
+// errorBody 错误响应内容
+type errorBody struct {
+	Message string
+	Details map[string]any
+}
+
+// NewError 创建服务错误
+func NewError(code easyCon.EResp, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf 创建服务错误 错误信息按format格式化
+func Errorf(code easyCon.EResp, format string, args ...any) *Error {
+	return NewError(code, fmt.Sprintf(format, args...))
+}
+
+// WithDetail 返回附加了详细信息的新错误
+func (e *Error) WithDetail(key string, value any) *Error {
+	ne := *e
+	ne.Details = make(map[string]any, len(e.Details)+1)
+	for k, v := range e.Details {
+		ne.Details[k] = v
+	}
+	ne.Details[key] = value
+	return &ne
+}
+
+// WithCause 返回附加了原始错误的新错误
+func (e *Error) WithCause(err error) *Error {
+	ne := *e
+	ne.Cause = err
+	return &ne
+}
+
+// WithMessage 返回替换了错误信息的新错误
+func (e *Error) WithMessage(message string) *Error {
+	ne := *e
+	ne.Message = message
+	return &ne
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%v:%s, %s", e.Code, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%v:%s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is 响应码相同即视为相同错误
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// body 转为响应内容 没有详细信息时只返回错误信息 兼容旧版本调用方
+func (e *Error) body() any {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	return errorBody{Message: e.Message, Details: e.Details}
+}
+
+// errorOfResp 将调用方收到的异常响应转为服务错误
+func errorOfResp(resp easyCon.PackResp) *Error {
+	err := NewError(resp.RespCode, "")
+	switch c := resp.Content.(type) {
+	case string:
+		err.Message = c
+	case map[string]any:
+		if body, e := qconvert.ToAnyError[errorBody](c); e == nil {
+			err.Message = body.Message
+			err.Details = body.Details
+		}
+	}
+	if err.Message == "" {
+		switch resp.RespCode {
+		case easyCon.ERespUnLinked:
+			err.Message = ErrUnLinked.Message
+		case easyCon.ERespBadReq:
+			err.Message = ErrBadReq.Message
+		case easyCon.ERespForbidden:
+			err.Message = ErrForbidden.Message
+		case easyCon.ERespRouteNotFind:
+			err.Message = ErrRouteNotFind.Message
+		case easyCon.ERespTimeout:
+			err.Message = ErrTimeout.Message
+		case ERespBusy:
+			err.Message = ErrBusy.Message
+		default:
+			err.Message = resp.Error
+		}
+	} else if resp.Error != "" {
+		err.Message += ", " + resp.Error
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package qservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kamioair/quick-utils/qconfig"
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qio"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"strings"
+	"time"
+)
+
+// redactMask 脱敏后的替换内容
+const redactMask = "***"
+
+// ErrorLogConfig 错误日志配置
+type ErrorLogConfig struct {
+	Path       string   // 文件路径
+	MaxSize    int      // 单个文件的最大大小 MB
+	MaxAge     int      // 备份保留天数
+	MaxBackups int      // 备份保留个数
+	Redact     []string // 需要脱敏的键 不区分大小写，键名包含即脱敏
+}
+
+// ErrorRecord 错误日志记录 每条记录为一行json
+type ErrorRecord struct {
+	Time    string
+	Module  string
+	Type    string        // 来源 例如service.onReq
+	Route   string        // 请求或通知路由
+	From    string        // 调用方模块
+	Code    easyCon.EResp // 响应码
+	Error   string
+	TraceId string `json:",omitempty"` // 链路Id
+	SpanId  string `json:",omitempty"` // 调用段Id
+	Content any    `json:",omitempty"` // 脱敏后的请求内容
+	Stack   string `json:",omitempty"` // panic时的调用栈
+}
+
+// loadErrorLogConfig 从配置文件读取错误日志配置
+func loadErrorLogConfig(module string) ErrorLogConfig {
+	return ErrorLogConfig{
+		Path:       qconfig.Get(module, "log.error.path", "./log/error.log"),
+		MaxSize:    qconfig.Get(module, "log.error.maxSize", 10),
+		MaxAge:     qconfig.Get(module, "log.error.maxAge", 30),
+		MaxBackups: qconfig.Get(module, "log.error.maxBackups", 10),
+		Redact:     qconfig.Get(module, "log.error.redact", []string{"Password", "Pwd", "Token", "Secret"}),
+	}
+}
+
+// SetErrorLog 设置错误日志配置
+func (s *Setting) SetErrorLog(config ErrorLogConfig) *Setting {
+	s.errorLog = config
+	return s
+}
+
+// errJournal 错误日志
+type errJournal struct {
+	writer *qio.RotateWriter
+	redact []string
+}
+
+func newErrJournal(config ErrorLogConfig) *errJournal {
+	if config.Path == "" {
+		config.Path = "./log/error.log"
+	}
+	redact := make([]string, 0, len(config.Redact))
+	for _, key := range config.Redact {
+		redact = append(redact, strings.ToLower(key))
+	}
+	return &errJournal{
+		writer: qio.GetRotateWriter(config.Path, int64(config.MaxSize)*1024*1024,
+			time.Duration(config.MaxAge)*24*time.Hour, config.MaxBackups),
+		redact: redact,
+	}
+}
+
+// write 写入一条记录
+func (j *errJournal) write(rec ErrorRecord) {
+	rec.Content = j.redactValue(rec.Content)
+	js, err := json.Marshal(rec)
+	if err != nil {
+		rec.Content = fmt.Sprintf("%v", rec.Content)
+		js, _ = json.Marshal(rec)
+	}
+	_, _ = j.writer.Write(append(js, '\n'))
+}
+
+// recent 从新到旧读取最近的记录 route不为空时只返回该路由的记录
+// 从文件末尾向前读取，读够count条即停止
+func (j *errJournal) recent(count int, route string) []ErrorRecord {
+	list := make([]ErrorRecord, 0)
+	for _, file := range j.writer.Files() {
+		_ = qio.EachLineReverse(file, func(line []byte) bool {
+			rec := ErrorRecord{}
+			if json.Unmarshal(line, &rec) != nil {
+				return true
+			}
+			if route == "" || rec.Route == route {
+				list = append(list, rec)
+			}
+			return len(list) < count
+		})
+		if len(list) >= count {
+			break
+		}
+	}
+	return list
+}
+
+// redactValue 将需要脱敏的键的值替换为***
+func (j *errJournal) redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			if j.sensitive(k) {
+				m[k] = redactMask
+			} else {
+				m[k] = j.redactValue(item)
+			}
+		}
+		return m
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = j.redactValue(item)
+		}
+		return list
+	}
+	return value
+}
+
+func (j *errJournal) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, r := range j.redact {
+		if strings.Contains(key, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeErrLog 写入错误日志 content为请求或通知的原始内容 sc为处理时的链路标识
+func (serv *MicroService) writeErrLog(tp, route, from string, content any, sc SpanContext, err error) {
+	_, content, _ = unwrapMeta(content)
+	rec := ErrorRecord{
+		Time:    time.Now().Format(reqTimeFormat),
+		Module:  serv.setting.Module,
+		Type:    tp,
+		Route:   route,
+		From:    from,
+		Code:    easyCon.ERespError,
+		Error:   err.Error(),
+		TraceId: sc.TraceId,
+		SpanId:  sc.SpanId,
+		Content: content,
+	}
+	var se *Error
+	if errors.As(err, &se) {
+		rec.Code = se.Code
+	}
+	var pe *panicError
+	if errors.As(err, &pe) {
+		rec.Stack = pe.stack
+	}
+	serv.journal.write(rec)
+}
+
+// onErrors 内置路由@Errors 查询最近的错误日志
+// 参数 Count 返回条数，默认50，最多1000；Route 只返回该路由的记录
+func (serv *MicroService) onErrors(ctx qdefine.Context) []ErrorRecord {
+	count := ctx.GetIntOr("Count", 50)
+	if count <= 0 {
+		count = 50
+	} else if count > 1000 {
+		count = 1000
+	}
+	return serv.journal.recent(count, ctx.GetStringOr("Route", ""))
+}
@@ -0,0 +1,121 @@
+package qservice
+
+import (
+	"context"
+	"github.com/kamioair/quick-utils/qconfig"
+	"time"
+)
+
+// LimitConfig 请求并发限制 超过限制的请求排队等待
+type LimitConfig struct {
+	MaxConcurrent int          // 同时处理的请求数 小于等于0时不限制
+	QueueTimeout  int          // 排队的最长等待时间 毫秒，小于等于0时等待至调用方超时
+	Routes        []RouteLimit // 单个路由的并发限制
+}
+
+// RouteLimit 单个路由的并发限制
+type RouteLimit struct {
+	Route string
+	Limit int // 同时处理的请求数 小于等于0时不限制
+}
+
+// loadLimitConfig 从配置文件读取并发限制
+func loadLimitConfig(module string) LimitConfig {
+	return LimitConfig{
+		MaxConcurrent: qconfig.Get(module, "limit.maxConcurrent", 0),
+		QueueTimeout:  qconfig.Get(module, "limit.queueTimeout", 3000),
+		Routes:        qconfig.Get(module, "limit.routes", []RouteLimit{}),
+	}
+}
+
+// SetLimit 设置请求并发限制
+func (s *Setting) SetLimit(config LimitConfig) *Setting {
+	s.limit = config
+	return s
+}
+
+// SetRouteLimit 设置单个路由的并发限制 替换已有的设置
+func (s *Setting) SetRouteLimit(route string, limit int) *Setting {
+	for i, rl := range s.limit.Routes {
+		if rl.Route == route {
+			s.limit.Routes[i].Limit = limit
+			return s
+		}
+	}
+	s.limit.Routes = append(s.limit.Routes, RouteLimit{Route: route, Limit: limit})
+	return s
+}
+
+// limiter 请求并发限制 内置状态路由不受限制，便于服务繁忙时查看状态，文件传输路由受限制
+type limiter struct {
+	global  chan struct{}            // 全局的处理槽位 为空时不限制
+	routes  map[string]chan struct{} // 各路由的处理槽位
+	timeout time.Duration
+	metrics *metrics
+}
+
+func newLimiter(config LimitConfig, m *metrics) *limiter {
+	l := &limiter{
+		routes:  make(map[string]chan struct{}),
+		timeout: time.Duration(config.QueueTimeout) * time.Millisecond,
+		metrics: m,
+	}
+	if config.MaxConcurrent > 0 {
+		l.global = make(chan struct{}, config.MaxConcurrent)
+	}
+	for _, rl := range config.Routes {
+		if rl.Limit > 0 {
+			l.routes[rl.Route] = make(chan struct{}, rl.Limit)
+		}
+	}
+	return l
+}
+
+// acquire 获取处理槽位 先获取路由槽位再获取全局槽位，避免排队中的请求占用全局槽位
+// name为路由的统计名称，排队超时或调用方已超时时返回ErrBusy
+func (l *limiter) acquire(ctx context.Context, route, name string) (func(), error) {
+	slots := make([]chan struct{}, 0, 2)
+	if !reservedRoutes[route] {
+		if slot, ok := l.routes[route]; ok {
+			slots = append(slots, slot)
+		}
+		if l.global != nil {
+			slots = append(slots, l.global)
+		}
+	}
+
+	held := 0
+	release := func() {
+		for i := held - 1; i >= 0; i-- {
+			<-slots[i]
+		}
+	}
+	if len(slots) > 0 {
+		var timeout <-chan time.Time
+		if l.timeout > 0 {
+			timer := time.NewTimer(l.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		l.metrics.gauge(name, 0, 1)
+		for _, slot := range slots {
+			select {
+			case slot <- struct{}{}:
+				held++
+				continue
+			case <-timeout:
+			case <-ctx.Done():
+			}
+			l.metrics.gauge(name, 0, -1)
+			release()
+			return nil, ErrBusy.WithDetail("Route", route)
+		}
+		l.metrics.gauge(name, 0, -1)
+	}
+
+	l.metrics.gauge(name, 1, 0)
+	return func() {
+		l.metrics.gauge(name, -1, 0)
+		release()
+	}, nil
+}
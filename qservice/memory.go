@@ -0,0 +1,297 @@
+package qservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kamioair/quick-utils/qlog"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdapterFactory 访问器工厂 默认为easyCon.NewMqttAdapter
+type AdapterFactory func(setting easyCon.Setting) easyCon.IAdapter
+
+// MemoryBus 进程内的消息总线 用于单进程运行和测试，无需MQTT服务器
+// 请求和通知都经过json序列化，与经过MQTT传输的效果一致
+//
+//	bus := qservice.NewMemoryBus()
+//	setting.SetAdapterFactory(bus.NewAdapter)
+type MemoryBus struct {
+	adapters map[string]*memoryAdapter
+	retain   *easyCon.PackNotice
+	logger   *qlog.Logger // 控制台日志 不使用默认日志，避免上传日志时循环调用
+	lock     sync.RWMutex
+}
+
+// NewMemoryBus 创建进程内消息总线
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		adapters: make(map[string]*memoryAdapter),
+		logger:   qlog.New("MemoryBus", qlog.LevelDebug, qlog.NewConsoleSink()),
+	}
+}
+
+// NewAdapter 创建连接到总线的访问器 方法签名与AdapterFactory一致
+func (bus *MemoryBus) NewAdapter(setting easyCon.Setting) easyCon.IAdapter {
+	adapter := &memoryAdapter{bus: bus, setting: setting}
+	adapter.link()
+	return adapter
+}
+
+// Modules 返回已连接的模块名称
+func (bus *MemoryBus) Modules() []string {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	list := make([]string, 0, len(bus.adapters))
+	for module := range bus.adapters {
+		list = append(list, module)
+	}
+	return list
+}
+
+func (bus *MemoryBus) get(module string) *memoryAdapter {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	return bus.adapters[module]
+}
+
+// all 返回所有已连接的访问器
+func (bus *MemoryBus) all() []*memoryAdapter {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+	list := make([]*memoryAdapter, 0, len(bus.adapters))
+	for _, adapter := range bus.adapters {
+		list = append(list, adapter)
+	}
+	return list
+}
+
+var memoryId uint64
+
+// memoryAdapter 进程内访问器
+type memoryAdapter struct {
+	bus      *MemoryBus
+	setting  easyCon.Setting
+	isLinked atomic.Bool
+}
+
+func (adapter *memoryAdapter) link() {
+	bus := adapter.bus
+	bus.lock.Lock()
+	bus.adapters[adapter.setting.Module] = adapter
+	retain := bus.retain
+	bus.lock.Unlock()
+	adapter.isLinked.Store(true)
+	adapter.statusChanged(easyCon.EStatusLinked)
+
+	// 与MQTT一样，订阅后收到最后一条保留通知
+	if retain != nil && adapter.setting.OnRetainNotice != nil {
+		go adapter.setting.OnRetainNotice(*retain)
+	}
+}
+
+func (adapter *memoryAdapter) Stop() {
+	if !adapter.isLinked.Swap(false) {
+		return
+	}
+	bus := adapter.bus
+	bus.lock.Lock()
+	if bus.adapters[adapter.setting.Module] == adapter {
+		delete(bus.adapters, adapter.setting.Module)
+	}
+	bus.lock.Unlock()
+	adapter.statusChanged(easyCon.EStatusStopped)
+}
+
+func (adapter *memoryAdapter) Reset() {
+	adapter.Stop()
+	adapter.link()
+}
+
+func (adapter *memoryAdapter) Req(module, route string, params any) easyCon.PackResp {
+	if !adapter.isLinked.Load() {
+		return easyCon.PackResp{RespCode: easyCon.ERespUnLinked}
+	}
+	pack := easyCon.PackReq{
+		From:    adapter.setting.Module,
+		ReqTime: time.Now().Format(reqTimeFormat),
+		To:      module,
+		Route:   route,
+		Content: params,
+	}
+	pack.PType = easyCon.EPTypeReq
+	pack.Id = atomic.AddUint64(&memoryId, 1)
+	for retry := adapter.setting.ReTry; retry > 0; retry-- {
+		resp := adapter.req(pack)
+		if resp.RespCode != easyCon.ERespTimeout {
+			return resp
+		}
+	}
+	resp := easyCon.PackResp{PackReq: pack, RespCode: easyCon.ERespTimeout, Error: "Req timeout"}
+	resp.Content = nil
+	return resp
+}
+
+// req 发送一次请求 目标模块不存在或处理超时都返回超时
+func (adapter *memoryAdapter) req(pack easyCon.PackReq) easyCon.PackResp {
+	if err := RoundTrip(&pack); err != nil {
+		return easyCon.PackResp{RespCode: easyCon.ERespBadReq, Error: err.Error()}
+	}
+	ch := make(chan easyCon.PackResp, 1)
+	go func() {
+		resp, ok := adapter.bus.dispatch(pack)
+		if !ok {
+			return
+		}
+		if err := RoundTrip(&resp); err != nil {
+			resp = easyCon.PackResp{PackReq: pack, RespCode: easyCon.ERespError, Error: err.Error()}
+		}
+		ch <- resp
+	}()
+	select {
+	case resp := <-ch:
+		return resp
+	case <-time.After(adapter.setting.TimeOut):
+		return easyCon.PackResp{RespCode: easyCon.ERespTimeout}
+	}
+}
+
+// dispatch 将请求交给目标模块处理 目标模块不存在时返回false，由调用方等待至超时
+// 没有Route模块时由总线完成路由请求的转发
+func (bus *MemoryBus) dispatch(pack easyCon.PackReq) (easyCon.PackResp, bool) {
+	target := bus.get(pack.To)
+	if target == nil && pack.To == "Route" && pack.Route == "Request" {
+		_, content, _ := unwrapMeta(pack.Content)
+		if m, ok := content.(map[string]any); ok {
+			module := fmt.Sprintf("%v", m["Module"])
+			target = bus.get(module)
+			if target == nil {
+				target = bus.get(module[strings.LastIndex(module, "/")+1:])
+			}
+			pack.To = module
+			pack.Route = fmt.Sprintf("%v", m["Route"])
+			pack.Content = m["Content"]
+		}
+	}
+	if target == nil || target.setting.OnReq == nil {
+		return easyCon.PackResp{}, false
+	}
+	code, content := target.setting.OnReq(pack)
+	resp := easyCon.PackResp{
+		PackReq:  pack,
+		RespTime: time.Now().Format(reqTimeFormat),
+		RespCode: code,
+	}
+	resp.PType = easyCon.EPTypeResp
+	resp.Content = content
+	return resp, true
+}
+
+func (adapter *memoryAdapter) SendNotice(route string, content any) error {
+	return adapter.sendNotice(route, false, content)
+}
+
+func (adapter *memoryAdapter) SendRetainNotice(route string, content any) error {
+	return adapter.sendNotice(route, true, content)
+}
+
+func (adapter *memoryAdapter) sendNotice(route string, isRetain bool, content any) error {
+	if !adapter.isLinked.Load() {
+		return fmt.Errorf("adapter is not linked")
+	}
+	pack := easyCon.PackNotice{
+		From:    adapter.setting.Module,
+		Route:   route,
+		Content: content,
+	}
+	pack.PType = easyCon.EPTypeNotice
+	pack.Id = atomic.AddUint64(&memoryId, 1)
+	if err := RoundTrip(&pack); err != nil {
+		adapter.Err("Notice marshal error", err)
+		return err
+	}
+	if isRetain {
+		adapter.bus.lock.Lock()
+		adapter.bus.retain = &pack
+		adapter.bus.lock.Unlock()
+	}
+	// 在发送方协程中依次分发，与MQTT一样，发送方订阅了通知时也会收到自己的通知
+	for _, a := range adapter.bus.all() {
+		handler := a.setting.OnNotice
+		if isRetain {
+			handler = a.setting.OnRetainNotice
+		}
+		if handler != nil {
+			handler(pack)
+		}
+	}
+	return nil
+}
+
+func (adapter *memoryAdapter) Debug(content string) {
+	adapter.log(easyCon.ELogLevelDebug, content, nil)
+}
+
+func (adapter *memoryAdapter) Warn(content string) {
+	adapter.log(easyCon.ELogLevelWarning, content, nil)
+}
+
+func (adapter *memoryAdapter) Err(content string, err error) {
+	adapter.log(easyCon.ELogLevelError, content, err)
+}
+
+func (adapter *memoryAdapter) log(level easyCon.ELogLevel, content string, err error) {
+	pack := easyCon.PackLog{
+		From:    adapter.setting.Module,
+		Level:   level,
+		LogTime: time.Now().Format(reqTimeFormat),
+		Content: content,
+	}
+	pack.PType = easyCon.EPTypeLog
+	pack.Id = atomic.AddUint64(&memoryId, 1)
+	if err != nil {
+		pack.Error = err.Error()
+	}
+	mode := adapter.setting.LogMode
+	if mode == easyCon.ELogModeConsole || mode == easyCon.ELogModeAll {
+		kv := []any{"From", pack.From}
+		if err != nil {
+			kv = append(kv, "Error", err)
+		}
+		logger := adapter.bus.logger
+		switch level {
+		case easyCon.ELogLevelError:
+			logger.Error(content, kv...)
+		case easyCon.ELogLevelWarning:
+			logger.Warn(content, kv...)
+		default:
+			logger.Debug(content, kv...)
+		}
+	}
+	if mode == easyCon.ELogModeUpload || mode == easyCon.ELogModeAll {
+		for _, a := range adapter.bus.all() {
+			if a.setting.OnLog != nil {
+				go a.setting.OnLog(pack)
+			}
+		}
+	}
+}
+
+func (adapter *memoryAdapter) statusChanged(status easyCon.EStatus) {
+	if adapter.setting.StatusChanged != nil {
+		adapter.setting.StatusChanged(adapter, status)
+	}
+}
+
+// RoundTrip 经过json序列化再反序列化 模拟网络传输
+func RoundTrip[T any](pack *T) error {
+	js, err := json.Marshal(pack)
+	if err != nil {
+		return err
+	}
+	*pack = *new(T)
+	return json.Unmarshal(js, pack)
+}
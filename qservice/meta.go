@@ -0,0 +1,63 @@
+package qservice
+
+import (
+	"github.com/kamioair/quick-utils/qconvert"
+	"time"
+)
+
+// 请求内容的信封键名
+// 开启元数据时请求和通知内容包装为 {"@Meta":{...},"@Content":原内容}
+const (
+	metaKey    = "@Meta"
+	contentKey = "@Content"
+)
+
+// reqTimeFormat easyCon请求包ReqTime的时间格式
+const reqTimeFormat = "2006-01-02 15:04:05.000"
+
+// meta 随请求发送的元数据
+type meta struct {
+	Timeout int64  // 调用方等待时长 毫秒
+	TraceId string `json:",omitempty"` // 链路Id
+	SpanId  string `json:",omitempty"` // 调用方的调用段Id
+}
+
+// wrapMeta 将元数据和请求内容包装为信封
+func wrapMeta(m meta, params any) any {
+	return map[string]any{
+		metaKey:    m,
+		contentKey: params,
+	}
+}
+
+// unwrapMeta 拆开信封 不是信封时原样返回内容
+func unwrapMeta(content any) (meta, any, bool) {
+	m, ok := content.(map[string]any)
+	if !ok {
+		return meta{}, content, false
+	}
+	raw, ok := m[metaKey]
+	if !ok {
+		return meta{}, content, false
+	}
+	md, err := qconvert.ToAnyError[meta](raw)
+	if err != nil {
+		return meta{}, content, false
+	}
+	return md, m[contentKey], true
+}
+
+// StripMeta 去掉请求或通知内容的元数据信封 用于自定义访问器和测试
+func StripMeta(content any) any {
+	_, content, _ = unwrapMeta(content)
+	return content
+}
+
+// parseReqTime 解析请求包的发送时间 解析失败返回零值
+func parseReqTime(str string) time.Time {
+	t, err := time.ParseInLocation(reqTimeFormat, str, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,103 @@
+package qservice
+
+import (
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets 耗时直方图的分段上限 毫秒
+var latencyBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// RouteMetrics 单个路由的统计
+type RouteMetrics struct {
+	Count   uint64                   // 请求总数
+	Errors  uint64                   // 失败数 响应码不为200
+	Codes   map[easyCon.EResp]uint64 // 各响应码的数量
+	TotalMs float64                  // 总耗时 毫秒
+	MaxMs   float64                  // 最大耗时 毫秒
+	Buckets []LatencyBucket          // 耗时直方图
+	Running int64                    // 当前处理中的数量
+	Queued  int64                    // 当前排队等待的数量
+}
+
+// LatencyBucket 耗时直方图的分段 Le为0表示超过最后一个分段
+type LatencyBucket struct {
+	Le    float64 // 分段上限 毫秒
+	Count uint64  // 耗时小于等于上限的数量 不累计前面的分段
+}
+
+// unknownRoute 未注册路由的统计名称 避免任意路由名使统计无限增长
+const unknownRoute = "<unknown>"
+
+// metrics 请求统计 只按内置路由和Router中注册的路由分别统计，其余请求计入unknownRoute
+type metrics struct {
+	routes map[string]*RouteMetrics
+	lock   sync.Mutex
+}
+
+func newMetrics() *metrics {
+	return &metrics{routes: make(map[string]*RouteMetrics)}
+}
+
+// record 记录一次请求
+func (m *metrics) record(route string, code easyCon.EResp, elapsed time.Duration) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rm := m.route(route)
+	rm.Count++
+	if code != easyCon.ERespSuccess {
+		rm.Errors++
+	}
+	rm.Codes[code]++
+	rm.TotalMs += ms
+	if ms > rm.MaxMs {
+		rm.MaxMs = ms
+	}
+	i := sort.SearchFloat64s(latencyBuckets, ms)
+	rm.Buckets[i].Count++
+}
+
+// gauge 修改处理中和排队等待的数量
+func (m *metrics) gauge(route string, running, queued int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rm := m.route(route)
+	rm.Running += running
+	rm.Queued += queued
+}
+
+// route 返回路由的统计 不存在时创建
+func (m *metrics) route(route string) *RouteMetrics {
+	rm, ok := m.routes[route]
+	if !ok {
+		rm = &RouteMetrics{
+			Codes:   make(map[easyCon.EResp]uint64),
+			Buckets: make([]LatencyBucket, len(latencyBuckets)+1),
+		}
+		for i, le := range latencyBuckets {
+			rm.Buckets[i].Le = le
+		}
+		m.routes[route] = rm
+	}
+	return rm
+}
+
+// snapshot 返回统计的副本
+func (m *metrics) snapshot() map[string]RouteMetrics {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	list := make(map[string]RouteMetrics, len(m.routes))
+	for route, rm := range m.routes {
+		cp := *rm
+		cp.Codes = make(map[easyCon.EResp]uint64, len(rm.Codes))
+		for k, v := range rm.Codes {
+			cp.Codes[k] = v
+		}
+		cp.Buckets = append([]LatencyBucket{}, rm.Buckets...)
+		list[route] = cp
+	}
+	return list
+}
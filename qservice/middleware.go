@@ -0,0 +1,120 @@
+package qservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kamioair/quick-utils/qdefine"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"time"
+)
+
+type (
+	// ReqMiddleware 请求中间件 调用next继续执行 直接返回则中断后续执行
+	// 中断时可返回Abort生成的错误来指定响应码
+	ReqMiddleware func(route string, ctx qdefine.Context, next qdefine.ReqHandler) (any, error)
+	// NoticeMiddleware 通知中间件 不调用next则中断后续执行
+	NoticeMiddleware func(route string, ctx qdefine.Context, next qdefine.NoticeHandler)
+)
+
+// middlewares 中间件配置 全局中间件先于路由中间件执行 同类按注册顺序执行
+type middlewares struct {
+	req         []ReqMiddleware
+	routeReq    map[string][]ReqMiddleware
+	notice      []NoticeMiddleware
+	routeNotice map[string][]NoticeMiddleware
+}
+
+// Abort 生成中断错误 请求将以code作为响应码返回
+func Abort(code easyCon.EResp, msg string) error {
+	return NewError(code, msg)
+}
+
+// UseReq 添加全局请求中间件
+func (s *Setting) UseReq(mws ...ReqMiddleware) *Setting {
+	s.middlewares.req = append(s.middlewares.req, mws...)
+	return s
+}
+
+// UseReqFor 添加指定路由的请求中间件
+func (s *Setting) UseReqFor(route string, mws ...ReqMiddleware) *Setting {
+	if s.middlewares.routeReq == nil {
+		s.middlewares.routeReq = make(map[string][]ReqMiddleware)
+	}
+	s.middlewares.routeReq[route] = append(s.middlewares.routeReq[route], mws...)
+	return s
+}
+
+// UseNotice 添加全局通知中间件
+func (s *Setting) UseNotice(mws ...NoticeMiddleware) *Setting {
+	s.middlewares.notice = append(s.middlewares.notice, mws...)
+	return s
+}
+
+// UseNoticeFor 添加指定路由的通知中间件
+func (s *Setting) UseNoticeFor(route string, mws ...NoticeMiddleware) *Setting {
+	if s.middlewares.routeNotice == nil {
+		s.middlewares.routeNotice = make(map[string][]NoticeMiddleware)
+	}
+	s.middlewares.routeNotice[route] = append(s.middlewares.routeNotice[route], mws...)
+	return s
+}
+
+// wrapReq 用中间件包装请求处理方法
+func (m *middlewares) wrapReq(route string, handler qdefine.ReqHandler) qdefine.ReqHandler {
+	list := append(append([]ReqMiddleware{}, m.req...), m.routeReq[route]...)
+	for i := len(list) - 1; i >= 0; i-- {
+		mw, next := list[i], handler
+		handler = func(route string, ctx qdefine.Context) (any, error) {
+			return mw(route, ctx, next)
+		}
+	}
+	return handler
+}
+
+// wrapNotice 用中间件包装通知处理方法
+func (m *middlewares) wrapNotice(route string, handler qdefine.NoticeHandler) qdefine.NoticeHandler {
+	list := append(append([]NoticeMiddleware{}, m.notice...), m.routeNotice[route]...)
+	for i := len(list) - 1; i >= 0; i-- {
+		mw, next := list[i], handler
+		handler = func(route string, ctx qdefine.Context) {
+			mw(route, ctx, next)
+		}
+	}
+	return handler
+}
+
+// ReqRecover 将处理方法中的panic转为500错误返回 不再写入异常日志
+func ReqRecover() ReqMiddleware {
+	return func(route string, ctx qdefine.Context, next qdefine.ReqHandler) (rs any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				rs, err = nil, Abort(easyCon.ERespError, fmt.Sprintf("%v", r))
+			}
+		}()
+		return next(route, ctx)
+	}
+}
+
+// ReqMaxSize 限制请求内容序列化后的字节数 超出时返回400
+func ReqMaxSize(limit int) ReqMiddleware {
+	return func(route string, ctx qdefine.Context, next qdefine.ReqHandler) (any, error) {
+		js, err := json.Marshal(ctx.Raw())
+		if err != nil {
+			return nil, Abort(easyCon.ERespBadReq, err.Error())
+		}
+		if len(js) > limit {
+			return nil, Abort(easyCon.ERespBadReq, fmt.Sprintf("request content is too large, %d > %d", len(js), limit))
+		}
+		return next(route, ctx)
+	}
+}
+
+// ReqTiming 统计请求耗时 每次请求结束后回调
+func ReqTiming(callback func(route string, cost time.Duration, err error)) ReqMiddleware {
+	return func(route string, ctx qdefine.Context, next qdefine.ReqHandler) (any, error) {
+		start := time.Now()
+		rs, err := next(route, ctx)
+		callback(route, time.Since(start), err)
+		return rs, err
+	}
+}
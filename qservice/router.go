@@ -0,0 +1,210 @@
+package qservice
+
+import (
+	"fmt"
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qdefine"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Router 路由注册表 每个路由绑定一个强类型的处理方法
+//
+//	router := qservice.NewRouter()
+//	qservice.Handle(router, "GetUser", func(ctx qdefine.Context, in *GetUserReq) (*User, error) {
+//		...
+//	})
+//	setting.BindRouter(router)
+type Router struct {
+	routes         map[string]*route
+	orders         []string
+	fallback       qdefine.ReqHandler
+	notices        map[string]qdefine.NoticeHandler
+	noticeFallback qdefine.NoticeHandler
+	lock           sync.RWMutex
+}
+
+// route 路由
+type route struct {
+	name    string
+	inType  reflect.Type // 输入类型 原始路由为空
+	outType reflect.Type // 输出类型 原始路由为空
+	handler qdefine.ReqHandler
+}
+
+// NewRouter 创建路由注册表
+func NewRouter() *Router {
+	return &Router{
+		routes:  make(map[string]*route),
+		orders:  make([]string, 0),
+		notices: make(map[string]qdefine.NoticeHandler),
+	}
+}
+
+// Handle 注册强类型路由 请求内容会自动解析为Req
+func Handle[Req any, Resp any](r *Router, name string, fn func(ctx qdefine.Context, in *Req) (*Resp, error)) *Router {
+	r.add(&route{
+		name:    name,
+		inType:  reflect.TypeOf((*Req)(nil)).Elem(),
+		outType: reflect.TypeOf((*Resp)(nil)).Elem(),
+		handler: func(_ string, ctx qdefine.Context) (any, error) {
+			in, err := decodeInput[Req](ctx)
+			if err != nil {
+				return nil, err
+			}
+			out, err := fn(ctx, in)
+			if err != nil {
+				return nil, err
+			}
+			if out == nil {
+				return nil, nil
+			}
+			return out, nil
+		},
+	})
+	return r
+}
+
+// HandleFunc 注册原始路由 由处理方法自行解析请求内容
+func (r *Router) HandleFunc(name string, fn func(ctx qdefine.Context) (any, error)) *Router {
+	r.add(&route{
+		name: name,
+		handler: func(_ string, ctx qdefine.Context) (any, error) {
+			return fn(ctx)
+		},
+	})
+	return r
+}
+
+// Fallback 设置未注册路由时的处理方法 便于逐步迁移旧的ReqHandler
+func (r *Router) Fallback(handler qdefine.ReqHandler) *Router {
+	r.fallback = handler
+	return r
+}
+
+// OnReq 分发请求 方法签名与qdefine.ReqHandler一致
+func (r *Router) OnReq(name string, ctx qdefine.Context) (any, error) {
+	r.lock.RLock()
+	rt, ok := r.routes[name]
+	r.lock.RUnlock()
+	if ok {
+		return rt.handler(name, ctx)
+	}
+	if r.fallback != nil {
+		return r.fallback(name, ctx)
+	}
+	return nil, ErrRouteNotFind
+}
+
+// HandleNotice 注册强类型通知 通知内容会自动解析为T 解析失败时不执行处理方法
+func HandleNotice[T any](r *Router, name string, fn func(ctx qdefine.Context, in *T)) *Router {
+	r.addNotice(name, func(_ string, ctx qdefine.Context) {
+		in, err := decodeInput[T](ctx)
+		if err != nil {
+			panic(err)
+		}
+		fn(ctx, in)
+	})
+	return r
+}
+
+// HandleNoticeFunc 注册原始通知 由处理方法自行解析通知内容
+func (r *Router) HandleNoticeFunc(name string, fn func(ctx qdefine.Context)) *Router {
+	r.addNotice(name, func(_ string, ctx qdefine.Context) {
+		fn(ctx)
+	})
+	return r
+}
+
+// FallbackNotice 设置未注册通知时的处理方法
+func (r *Router) FallbackNotice(handler qdefine.NoticeHandler) *Router {
+	r.noticeFallback = handler
+	return r
+}
+
+// OnNotice 分发通知 方法签名与qdefine.NoticeHandler一致
+func (r *Router) OnNotice(name string, ctx qdefine.Context) {
+	if handler, ok := r.notice(name); ok {
+		handler(name, ctx)
+		return
+	}
+	if r.noticeFallback != nil {
+		r.noticeFallback(name, ctx)
+	}
+}
+
+// has 判断路由是否已注册
+func (r *Router) has(name string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	_, ok := r.routes[name]
+	return ok
+}
+
+// notice 查找已注册的通知处理方法
+func (r *Router) notice(name string) (qdefine.NoticeHandler, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	handler, ok := r.notices[name]
+	return handler, ok
+}
+
+// Routes 按注册顺序返回所有路由名称
+func (r *Router) Routes() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]string{}, r.orders...)
+}
+
+// list 按注册顺序返回所有路由
+func (r *Router) list() []*route {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	list := make([]*route, 0, len(r.orders))
+	for _, name := range r.orders {
+		list = append(list, r.routes[name])
+	}
+	return list
+}
+
+func (r *Router) add(rt *route) {
+	if reservedRoutes[rt.name] || strings.HasPrefix(rt.name, builtinPrefix) {
+		panic(fmt.Errorf("route %s is reserved", rt.name))
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.routes[rt.name]; ok {
+		panic(fmt.Errorf("route %s is already registered", rt.name))
+	}
+	r.routes[rt.name] = rt
+	r.orders = append(r.orders, rt.name)
+}
+
+func (r *Router) addNotice(name string, handler qdefine.NoticeHandler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.notices[name]; ok {
+		panic(fmt.Errorf("notice %s is already registered", name))
+	}
+	r.notices[name] = handler
+}
+
+// decodeInput 将请求内容解析为指定类型 失败时返回400
+func decodeInput[T any](ctx qdefine.Context) (*T, error) {
+	in := new(T)
+	raw := ctx.Raw()
+	if raw == nil {
+		return in, nil
+	}
+	v, err := qconvert.ToAnyError[T](raw)
+	if err != nil {
+		return nil, ErrBadReq.WithMessage("request bad, " + err.Error())
+	}
+	*in = v
+	// 按valid标签校验
+	if err = Validate(in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
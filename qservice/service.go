@@ -1,22 +1,36 @@
 package qservice
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/kamioair/quick-utils/qdefine"
 	"github.com/kamioair/quick-utils/qio"
 	"github.com/kamioair/quick-utils/qlauncher"
+	"github.com/kamioair/quick-utils/qlog"
 	easyCon "github.com/qiu-tec/easy-con.golang"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type MicroService struct {
-	Module  string
-	adapter easyCon.IAdapter
-	setting *Setting
+	Module    string
+	adapter   easyCon.IAdapter
+	setting   *Setting
+	subs      []*Subscription // 通知订阅
+	subLock   sync.RWMutex
+	metrics   *metrics    // 请求统计
+	limiter   *limiter    // 请求并发限制
+	tracer    *tracer     // 调用段导出
+	journal   *errJournal // 错误日志
+	logger    *qlog.Logger
+	startTime time.Time // 启动时间
+	inflight  inflight  // 处理中的请求和通知
+	abandoned int64     // 已放弃等待但访问器仍在等待响应的请求数
+	stopOnce  sync.Once
 }
 
 // NewService 创建服务
@@ -29,10 +43,23 @@ func NewService(setting *Setting) *MicroService {
 
 	// 创建服务
 	serv := &MicroService{
-		Module:  setting.Module,
-		setting: setting,
+		Module:    setting.Module,
+		setting:   setting,
+		metrics:   newMetrics(),
+		journal:   newErrJournal(setting.errorLog),
+		startTime: time.Now(),
 	}
 
+	serv.limiter = newLimiter(setting.limit, serv.metrics)
+	serv.tracer = newTracer(setting.Module, setting.trace)
+
+	// 模块日志 同时作为各工具包的默认日志
+	serv.logger = qlog.Load(setting.Module)
+	if setting.logUpload {
+		serv.logger.AddSink(qlog.FuncSink(serv.uploadLog))
+	}
+	qlog.SetDefault(serv.logger)
+
 	// 启动访问器
 	serv.initAdapter()
 
@@ -56,6 +83,7 @@ func (serv *MicroService) ResetClient(code string) {
 	serv.setting.deviceCode = code
 	serv.setting.Module = module
 	serv.Module = module
+	serv.tracer.module = module
 
 	// 重新创建服务
 	serv.initAdapter()
@@ -69,46 +97,49 @@ func (serv *MicroService) newModuleName(module, code string) string {
 	return strings.Trim(str, ".")
 }
 
-// SendRequest 发送请求
+// SendRequest 发送请求 使用Broker配置的超时时间和重试次数
 func (serv *MicroService) SendRequest(module, route string, params any) (qdefine.Context, error) {
-	var resp easyCon.PackResp
-
-	if strings.Contains(module, "/") {
-		// 路由请求
-		newParams := map[string]any{}
-		newParams["Module"] = serv.newModuleName(module, serv.setting.deviceCode)
-		newParams["Route"] = route
-		newParams["Content"] = params
-		resp = serv.adapter.Req("Route", "Request", newParams)
-	} else {
-		// 常规请求
-		resp = serv.adapter.Req(serv.newModuleName(module, serv.setting.deviceCode), route, params)
-	}
-	if resp.RespCode == easyCon.ERespSuccess {
-		// 返回成功
-		return newControlResp(resp)
-	}
-	// 返回异常
-	if resp.RespCode == easyCon.ERespTimeout {
-		return nil, errors.New(fmt.Sprintf("%v:%s", resp.RespCode, "request timeout"))
-	}
-	if resp.RespCode == easyCon.ERespRouteNotFind {
-		return nil, errors.New(fmt.Sprintf("%v:%s", resp.RespCode, "request route not find"))
+	return serv.SendRequestCtx(context.Background(), module, route, params, serv.retryOptions()...)
+}
+
+// withMeta 按配置为请求内容附加元数据
+func (serv *MicroService) withMeta(params any, m meta) any {
+	if !serv.setting.meta {
+		return params
 	}
-	if resp.RespCode == easyCon.ERespForbidden {
-		return nil, errors.New(fmt.Sprintf("%v:%s", resp.RespCode, "request forbidden"))
+	return wrapMeta(m, params)
+}
+
+// withNoticeMeta 按配置为通知内容附加元数据 通知的接收方不固定，与请求分开配置
+func (serv *MicroService) withNoticeMeta(content any, m meta) any {
+	if !serv.setting.noticeMeta {
+		return content
 	}
-	return nil, errors.New(fmt.Sprintf("%v:%s,%s", resp.RespCode, resp.Content, resp.Error))
+	return wrapMeta(m, content)
 }
 
-// SendNotice 发送通知
+// SendNotice 发送通知 开始新的链路
 func (serv *MicroService) SendNotice(route string, content any) {
-	err := serv.adapter.SendNotice(route, content)
+	serv.SendNoticeCtx(context.Background(), route, content)
+}
+
+// SendNoticeCtx 发送通知 属于ctx中的链路
+func (serv *MicroService) SendNoticeCtx(ctx context.Context, route string, content any) {
+	err := serv.sendNotice(ctx, route, content)
 	if err != nil {
-		serv.SendLog("error", "Service Send Notice Error", err)
+		serv.SendLogCtx(ctx, qdefine.ELogError, "Service Send Notice Error", err)
 	}
 }
 
+// sendNotice 发送通知并导出调用段
+func (serv *MicroService) sendNotice(ctx context.Context, route string, content any) error {
+	parent, _ := SpanFromContext(ctx)
+	span := serv.tracer.start(SpanProducer, route, "", parent)
+	err := serv.adapter.SendNotice(route, serv.withNoticeMeta(content, meta{TraceId: span.TraceId, SpanId: span.SpanId}))
+	span.end(0, err)
+	return err
+}
+
 // SendLog 发送日志
 func (serv *MicroService) SendLog(logType qdefine.ELog, content string, err error) {
 	switch logType {
@@ -123,6 +154,34 @@ func (serv *MicroService) SendLog(logType qdefine.ELog, content string, err erro
 	}
 }
 
+// SendLogCtx 发送日志 ctx携带链路标识时附加到日志内容
+func (serv *MicroService) SendLogCtx(ctx context.Context, logType qdefine.ELog, content string, err error) {
+	if sc, ok := SpanFromContext(ctx); ok {
+		content = fmt.Sprintf("%s, TraceId=%s, SpanId=%s", content, sc.TraceId, sc.SpanId)
+	}
+	serv.SendLog(logType, content, err)
+}
+
+// Logger 模块日志
+func (serv *MicroService) Logger() *qlog.Logger {
+	return serv.logger
+}
+
+// uploadLog 通过访问器上传日志
+func (serv *MicroService) uploadLog(entry *qlog.Entry) {
+	if serv.adapter == nil {
+		return
+	}
+	switch entry.Level {
+	case qlog.LevelError:
+		serv.adapter.Err(entry.String(), nil)
+	case qlog.LevelWarn:
+		serv.adapter.Warn(entry.String())
+	default:
+		serv.adapter.Debug(entry.String())
+	}
+}
+
 func (serv *MicroService) initAdapter() {
 	// 先停止
 	if serv.adapter != nil {
@@ -134,10 +193,19 @@ func (serv *MicroService) initAdapter() {
 	apiSetting.OnNotice = serv.onNotice
 	apiSetting.UID = serv.setting.Broker.UId
 	apiSetting.PWD = serv.setting.Broker.Pwd
-	apiSetting.TimeOut = time.Duration(serv.setting.Broker.TimeOut) * time.Second
-	apiSetting.ReTry = serv.setting.Broker.Retry
+	// 超时和重试由SendRequestCtx控制，访问器只做单次请求，等待时间取上限
+	maxTimeOut := serv.setting.Broker.MaxTimeOut
+	if maxTimeOut < serv.setting.Broker.TimeOut {
+		maxTimeOut = serv.setting.Broker.TimeOut
+	}
+	apiSetting.TimeOut = time.Duration(maxTimeOut) * time.Millisecond
+	apiSetting.ReTry = 1
 	apiSetting.LogMode = easyCon.ELogMode(serv.setting.Broker.LogMode)
-	serv.adapter = easyCon.NewMqttAdapter(apiSetting)
+	factory := serv.setting.adapterFactory
+	if factory == nil {
+		factory = easyCon.NewMqttAdapter
+	}
+	serv.adapter = factory(apiSetting)
 
 	// 如果是路由模式，则问客户端管理模块请求上级客户端ID
 	if serv.setting.deviceCode != "" {
@@ -155,65 +223,149 @@ func (serv *MicroService) initAdapter() {
 }
 
 func (serv *MicroService) onReq(pack easyCon.PackReq) (code easyCon.EResp, resp any) {
-	defer errRecover(func(err string) {
-		code = easyCon.ERespError
-		resp = err
+	// 先于errRecover注册，才能统计到异常转换后的响应码
+	start := time.Now()
+	name := serv.metricRoute(pack.Route)
+	var span *activeSpan
+	var reqErr error
+	defer func() {
+		serv.metrics.record(name, code, time.Since(start))
+		if span != nil {
+			span.end(code, reqErr)
+		}
+	}()
+	// 停止后不再接收新的请求
+	if !serv.inflight.tryAdd() {
+		return RespOf(ErrShutdown)
+	}
+	defer serv.inflight.done()
+	var ctx *control
+	defer errRecover(func(err error) {
+		reqErr = err
+		code, resp = RespOf(err)
 		// 记录日志
-		writeErrLog("service.onReq", err)
+		if code == easyCon.ERespError {
+			serv.writeErrLog("service.onReq", pack.Route, pack.From, pack.Content, ctx.span(), err)
+		}
 	})
 
-	switch pack.Route {
+	ctx, err := newControlReq(pack, time.Duration(serv.setting.Broker.TimeOut)*time.Millisecond)
+	if err != nil {
+		return easyCon.ERespError, err.Error()
+	}
+	defer ctx.release()
+	ctx.bindLogger(serv.logger)
+	span = serv.tracer.startWith(SpanServer, pack.Route, pack.From, ctx.span(), ctx.parentSpan)
+	span.start = start
+	// 超过并发限制时排队等待
+	release, err := serv.limiter.acquire(ctx.Context(), pack.Route, name)
+	if err != nil {
+		reqErr = err
+		return RespOf(err)
+	}
+	defer release()
+	rs, err := serv.setting.middlewares.wrapReq(pack.Route, serv.dispatchReq)(pack.Route, ctx)
+	if err != nil {
+		reqErr = err
+		code, resp = RespOf(err)
+		if code == easyCon.ERespError {
+			serv.writeErrLog("service.onReq", pack.Route, pack.From, pack.Content, ctx.span(), err)
+		}
+		return code, resp
+	}
+	// 执行成功，返回结果
+	return easyCon.ERespSuccess, rs
+}
+
+// dispatchReq 执行内置路由或外置请求回调 位于中间件链的末端
+func (serv *MicroService) dispatchReq(route string, ctx qdefine.Context) (any, error) {
+	switch route {
 	case "Exit":
-		serv.onStop()
-		go func() {
-			time.Sleep(time.Millisecond * 100)
-			qlauncher.Exit()
-		}()
-		return easyCon.ERespSuccess, nil
+		// 由启动器执行onStop 等待处理中的请求完成后退出
+		// 不是通过启动器运行时只停止服务，不退出进程
+		if !qlauncher.Exit() {
+			go serv.onStop()
+		}
+		return nil, nil
 	case "Reset":
 		serv.adapter.Reset()
-		return easyCon.ERespSuccess, nil
+		return nil, nil
+	}
+	if rs, ok := serv.onBuiltin(route, ctx); ok {
+		return rs, nil
 	}
 	if serv.setting.onReqHandler != nil {
-		ctx, err1 := newControlReq(pack)
-		if err1 != nil {
-			return easyCon.ERespError, err1.Error()
-		}
-		rs, err2 := serv.setting.onReqHandler(pack.Route, ctx)
-		if err2 != nil {
-			c, _ := strconv.Atoi(err2.Error())
-			switch c {
-			case int(easyCon.ERespBadReq):
-				return easyCon.ERespBadReq, "request bad"
-			case int(easyCon.ERespRouteNotFind):
-				return easyCon.ERespRouteNotFind, "request route not find"
-			case int(easyCon.ERespForbidden):
-				return easyCon.ERespForbidden, "request forbidden"
-			case int(easyCon.ERespTimeout):
-				return easyCon.ERespTimeout, "request timeout"
-			default:
-				return easyCon.ERespError, err2.Error()
-			}
-		}
-		// 执行成功，返回结果
-		return easyCon.ERespSuccess, rs
+		return serv.setting.onReqHandler(route, ctx)
+	}
+	return nil, ErrRouteNotFind.WithMessage("Route Not Matched")
+}
+
+// RespOf 将处理方法返回的错误转为响应码和响应内容 与调用方收到的响应一致
+func RespOf(err error) (easyCon.EResp, any) {
+	var se *Error
+	if errors.As(err, &se) {
+		return se.Code, se.body()
+	}
+	// 兼容旧写法 errors.New("404")
+	c, _ := strconv.Atoi(err.Error())
+	switch c {
+	case int(easyCon.ERespBadReq):
+		return ErrBadReq.Code, ErrBadReq.body()
+	case int(easyCon.ERespRouteNotFind):
+		return ErrRouteNotFind.Code, ErrRouteNotFind.body()
+	case int(easyCon.ERespForbidden):
+		return ErrForbidden.Code, ErrForbidden.body()
+	case int(easyCon.ERespTimeout):
+		return ErrTimeout.Code, ErrTimeout.body()
+	default:
+		return easyCon.ERespError, err.Error()
 	}
-	return easyCon.ERespRouteNotFind, "Route Not Matched"
 }
 
 func (serv *MicroService) onNotice(notice easyCon.PackNotice) {
-	defer errRecover(func(err string) {
+	// 停止后不再处理新的通知
+	if !serv.inflight.tryAdd() {
+		serv.logger.Debug("notice dropped, service shutting down", "Route", notice.Route)
+		return
+	}
+	defer serv.inflight.done()
+	var span *activeSpan
+	var noticeErr error
+	defer func() {
+		if span != nil {
+			span.end(0, noticeErr)
+		}
+	}()
+	var ctx *control
+	defer errRecover(func(err error) {
+		noticeErr = err
 		// 记录日志
-		writeErrLog("service.onNotice", err)
+		serv.writeErrLog("service.onNotice", notice.Route, notice.From, notice.Content, ctx.span(), err)
 	})
 
-	// 外置方法
-	if serv.setting.onNoticeHandler != nil {
-		ctx, err := newControlNotice(notice)
-		if err != nil {
-			panic(err)
+	// 路由注册表中的通知优先，其余交给外置方法
+	handler := serv.setting.onNoticeHandler
+	if serv.setting.router != nil {
+		if h, ok := serv.setting.router.notice(notice.Route); ok {
+			handler = h
 		}
-		serv.setting.onNoticeHandler(notice.Route, ctx)
+	}
+	subs := serv.subscriptions(notice.Route)
+	if handler == nil && len(subs) == 0 {
+		return
+	}
+	ctx, err := newControlNotice(notice)
+	if err != nil {
+		panic(err)
+	}
+	ctx.bindLogger(serv.logger)
+	span = serv.tracer.startWith(SpanConsumer, notice.Route, notice.From, ctx.span(), ctx.parentSpan)
+	// 订阅各自处理异常，不受外置方法影响
+	for _, sub := range subs {
+		sub.deliver(notice.Route, ctx)
+	}
+	if handler != nil {
+		serv.setting.middlewares.wrapNotice(notice.Route, handler)(notice.Route, ctx)
 	}
 }
 
@@ -233,9 +385,7 @@ func (serv *MicroService) onStart() {
 	}
 }
 
+// onStop 内置路由Exit和系统停止信号都会执行 只执行一次
 func (serv *MicroService) onStop() {
-	if serv.adapter != nil {
-		serv.adapter.Stop()
-		serv.adapter = nil
-	}
+	serv.stopOnce.Do(serv.shutdown)
 }
@@ -0,0 +1,122 @@
+package qservice
+
+import (
+	"context"
+	"github.com/kamioair/quick-utils/qconfig"
+	"sync"
+	"time"
+)
+
+// defDrainTimeout 停止时等待处理中请求的默认时间 毫秒
+const defDrainTimeout = 10000
+
+// ShutdownHook 停止钩子 在处理中的请求结束后、访问器停止前执行
+// ctx在停止等待时间到期后取消
+type ShutdownHook func(ctx context.Context)
+
+// OnShutdown 注册停止钩子 按注册的相反顺序执行
+func (s *Setting) OnShutdown(hook ShutdownHook) *Setting {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+	return s
+}
+
+// SetDrainTimeout 设置停止时等待处理中请求和执行停止钩子的最长时间
+func (s *Setting) SetDrainTimeout(timeout time.Duration) *Setting {
+	s.drainTimeout = timeout
+	return s
+}
+
+// loadDrainTimeout 从配置文件读取停止等待时间
+func loadDrainTimeout(module string) time.Duration {
+	return time.Duration(qconfig.Get(module, "shutdown.timeout", defDrainTimeout)) * time.Millisecond
+}
+
+// inflight 处理中的请求和通知 停止后不再接收新的处理
+type inflight struct {
+	count    int
+	stopping bool
+	idle     chan struct{} // 停止后全部处理完成时关闭
+	lock     sync.Mutex
+}
+
+// tryAdd 开始一个新的处理 停止后返回false
+func (f *inflight) tryAdd() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.stopping {
+		return false
+	}
+	f.count++
+	return true
+}
+
+// add 开始一个由处理中的请求派生的处理 例如订阅的异步处理 停止后仍然计数
+func (f *inflight) add() {
+	f.lock.Lock()
+	f.count++
+	f.lock.Unlock()
+}
+
+// done 结束一个处理
+func (f *inflight) done() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.count--
+	if f.stopping && f.count == 0 {
+		close(f.idle)
+	}
+}
+
+// stop 停止接收新的处理 返回全部处理完成时关闭的通道
+func (f *inflight) stop() <-chan struct{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.stopping {
+		f.stopping = true
+		f.idle = make(chan struct{})
+		if f.count == 0 {
+			close(f.idle)
+		}
+	}
+	return f.idle
+}
+
+// active 处理中的数量
+func (f *inflight) active() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.count
+}
+
+// shutdown 停止接收新的请求，等待处理中的请求完成后执行停止钩子，最后停止访问器
+func (serv *MicroService) shutdown() {
+	timeout := serv.setting.drainTimeout
+	if timeout <= 0 {
+		timeout = defDrainTimeout * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 等待处理中的请求
+	select {
+	case <-serv.inflight.stop():
+	case <-ctx.Done():
+		serv.logger.Warn("shutdown drain timeout", "Active", serv.inflight.active(), "Timeout", timeout)
+	}
+
+	// 停止钩子 单个钩子的异常不影响其他钩子
+	hooks := serv.setting.shutdownHooks
+	for i := len(hooks) - 1; i >= 0; i-- {
+		func() {
+			defer errRecover(func(err error) {
+				serv.writeErrLog("service.onShutdown", "", "", nil, SpanContext{}, err)
+			})
+			hooks[i](ctx)
+		}()
+	}
+
+	// 等待超时后可能仍有处理中的请求使用访问器，只停止不置空
+	if serv.adapter != nil {
+		serv.adapter.Stop()
+	}
+}
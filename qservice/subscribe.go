@@ -0,0 +1,239 @@
+package qservice
+
+import (
+	"github.com/kamioair/quick-utils/qdefine"
+	"strings"
+	"sync"
+)
+
+// Subscription 通知订阅
+//
+// pattern按 . 或 / 分段匹配通知路由：
+//
+//	Device.Status     精确匹配
+//	Device.*          * 匹配一段，如 Device.Status
+//	Device.#          # 匹配零到多段，如 Device、Device.Status.Changed
+//	Device.Stat*      以*结尾的段按前缀匹配，如 Device.Status、Device.State
+type Subscription struct {
+	pattern string
+	segs    []string
+	handler qdefine.NoticeHandler
+	serv    *MicroService
+	async   bool               // 并发模式
+	limit   chan struct{}      // 并发模式的并发数限制 为空时不限制
+	queue   chan subscribeItem // 顺序模式的队列
+	done    chan struct{}      // 取消订阅时关闭
+	sending sync.WaitGroup     // 正在分发的通知
+	closed  bool
+	lock    sync.RWMutex
+}
+
+type subscribeItem struct {
+	route string
+	ctx   qdefine.Context
+}
+
+// SubscribeOption 订阅选项
+type SubscribeOption func(sub *Subscription)
+
+// Ordered 在独立协程中按收到顺序逐个处理 buffer为队列长度 队列满时阻塞接收
+func Ordered(buffer int) SubscribeOption {
+	return func(sub *Subscription) {
+		sub.queue = make(chan subscribeItem, buffer)
+	}
+}
+
+// Concurrent 每个通知在独立协程中处理 limit为同时处理的数量，小于等于0时不限制
+func Concurrent(limit int) SubscribeOption {
+	return func(sub *Subscription) {
+		sub.async = true
+		if limit > 0 {
+			sub.limit = make(chan struct{}, limit)
+		}
+	}
+}
+
+// Subscribe 订阅通知 同一路由可以有多个订阅，按订阅顺序执行
+// 默认在接收协程中直接处理，可通过Ordered或Concurrent改为独立协程
+func (serv *MicroService) Subscribe(pattern string, handler qdefine.NoticeHandler, opts ...SubscribeOption) *Subscription {
+	sub := &Subscription{
+		pattern: pattern,
+		segs:    splitRoute(pattern),
+		handler: handler,
+		serv:    serv,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if sub.queue != nil {
+		go sub.loop()
+	}
+	serv.subLock.Lock()
+	serv.subs = append(serv.subs, sub)
+	serv.subLock.Unlock()
+	return sub
+}
+
+// SubscribeAs 订阅强类型通知 通知内容解析失败时不执行处理方法
+func SubscribeAs[T any](serv *MicroService, pattern string, fn func(ctx qdefine.Context, in *T), opts ...SubscribeOption) *Subscription {
+	return serv.Subscribe(pattern, func(_ string, ctx qdefine.Context) {
+		in, err := decodeInput[T](ctx)
+		if err != nil {
+			panic(err)
+		}
+		fn(ctx, in)
+	}, opts...)
+}
+
+// Pattern 订阅的路由规则
+func (sub *Subscription) Pattern() string {
+	return sub.pattern
+}
+
+// Unsubscribe 取消订阅 顺序模式下已入队的通知仍会处理完
+func (sub *Subscription) Unsubscribe() {
+	sub.lock.Lock()
+	if sub.closed {
+		sub.lock.Unlock()
+		return
+	}
+	sub.closed = true
+	close(sub.done)
+	sub.lock.Unlock()
+
+	serv := sub.serv
+	serv.subLock.Lock()
+	defer serv.subLock.Unlock()
+	for i, s := range serv.subs {
+		if s == sub {
+			serv.subs = append(serv.subs[:i:i], serv.subs[i+1:]...)
+			break
+		}
+	}
+}
+
+// deliver 按订阅的处理模式分发通知 分发时不持有锁，处理方法中可以取消订阅
+func (sub *Subscription) deliver(route string, ctx qdefine.Context) {
+	sub.lock.RLock()
+	if sub.closed {
+		sub.lock.RUnlock()
+		return
+	}
+	sub.sending.Add(1)
+	sub.lock.RUnlock()
+	defer sub.sending.Done()
+
+	switch {
+	case sub.queue != nil:
+		// 异步处理同样计入处理中，停止时等待其完成
+		sub.serv.inflight.add()
+		select {
+		case sub.queue <- subscribeItem{route: route, ctx: ctx}:
+		case <-sub.done:
+			sub.serv.inflight.done()
+		}
+	case sub.async:
+		if sub.limit != nil {
+			select {
+			case sub.limit <- struct{}{}:
+			case <-sub.done:
+				return
+			}
+		}
+		sub.serv.inflight.add()
+		go func() {
+			defer sub.serv.inflight.done()
+			if sub.limit != nil {
+				defer func() { <-sub.limit }()
+			}
+			sub.handle(route, ctx)
+		}()
+	default:
+		sub.handle(route, ctx)
+	}
+}
+
+// loop 顺序处理队列 取消订阅后等待正在分发的通知入队，处理完已入队的通知后退出
+func (sub *Subscription) loop() {
+	for {
+		select {
+		case item := <-sub.queue:
+			sub.handleItem(item)
+		case <-sub.done:
+			sub.sending.Wait()
+			for {
+				select {
+				case item := <-sub.queue:
+					sub.handleItem(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (sub *Subscription) handleItem(item subscribeItem) {
+	defer sub.serv.inflight.done()
+	sub.handle(item.route, item.ctx)
+}
+
+// handle 执行处理方法 单个订阅的异常不影响其他订阅
+func (sub *Subscription) handle(route string, ctx qdefine.Context) {
+	defer errRecover(func(err error) {
+		sc, _ := SpanFromContext(ctx.Context())
+		sub.serv.writeErrLog("service.onSubscribe", route, ctx.Source(), ctx.Raw(), sc, err)
+	})
+	sub.serv.setting.middlewares.wrapNotice(route, sub.handler)(route, ctx)
+}
+
+// match 判断通知路由是否符合订阅规则
+func (sub *Subscription) match(route string) bool {
+	return matchSegs(sub.segs, splitRoute(route))
+}
+
+// subscriptions 返回与通知路由匹配的订阅
+func (serv *MicroService) subscriptions(route string) []*Subscription {
+	serv.subLock.RLock()
+	defer serv.subLock.RUnlock()
+	list := make([]*Subscription, 0)
+	for _, sub := range serv.subs {
+		if sub.match(route) {
+			list = append(list, sub)
+		}
+	}
+	return list
+}
+
+func matchSegs(pattern, route []string) bool {
+	if len(pattern) == 0 {
+		return len(route) == 0
+	}
+	switch p := pattern[0]; {
+	case p == "#":
+		for i := 0; i <= len(route); i++ {
+			if matchSegs(pattern[1:], route[i:]) {
+				return true
+			}
+		}
+		return false
+	case len(route) == 0:
+		return false
+	case p == "*":
+	case strings.HasSuffix(p, "*"):
+		if !strings.HasPrefix(route[0], strings.TrimSuffix(p, "*")) {
+			return false
+		}
+	case p != route[0]:
+		return false
+	}
+	return matchSegs(pattern[1:], route[1:])
+}
+
+// splitRoute 按 . 或 / 拆分路由
+func splitRoute(route string) []string {
+	return strings.FieldsFunc(route, func(r rune) bool {
+		return r == '.' || r == '/'
+	})
+}
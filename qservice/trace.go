@@ -0,0 +1,197 @@
+package qservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kamioair/quick-utils/qconfig"
+	"github.com/kamioair/quick-utils/qio"
+	"github.com/kamioair/quick-utils/qlog"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"time"
+)
+
+// 链路追踪
+// 链路Id和调用段Id随请求和通知的元数据发送（需开启mqtt.meta、mqtt.noticeMeta），
+// 处理方法中使用ctx.Context()发送的请求和通知属于同一链路，ctx.Logger()的日志和错误日志都携带链路标识
+//
+//	func onReq(route string, ctx qdefine.Context) (any, error) {
+//		rs, err := serv.SendRequestCtx(ctx.Context(), "Db", "Query", params)
+//		ctx.Logger().Info("query done")
+//	}
+
+// 调用段类型
+const (
+	SpanServer   = "server"   // 处理请求
+	SpanClient   = "client"   // 发送请求
+	SpanConsumer = "consumer" // 处理通知
+	SpanProducer = "producer" // 发送通知
+)
+
+// SpanContext 链路标识
+type SpanContext struct {
+	TraceId string // 链路Id 同一次调用链共享
+	SpanId  string // 调用段Id
+}
+
+// IsValid 是否携带链路Id
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != ""
+}
+
+type spanKey struct{}
+
+// ContextWithSpan 返回携带链路标识的上下文
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanFromContext 获取上下文中的链路标识
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Span 已完成的调用段 每条为一行json
+type Span struct {
+	TraceId      string
+	SpanId       string
+	ParentSpanId string `json:",omitempty"`
+	Module       string
+	Kind         string // server|client|consumer|producer
+	Route        string
+	Peer         string        `json:",omitempty"` // 对方模块 发送通知时为空
+	Start        string        // 开始时间
+	DurationMs   float64       // 耗时 毫秒
+	Code         easyCon.EResp `json:",omitempty"` // 响应码 通知为空
+	Error        string        `json:",omitempty"`
+}
+
+// TraceConfig 调用段导出配置
+type TraceConfig struct {
+	Enable     bool   // 是否导出 链路标识始终随请求发送
+	Path       string // 文件路径
+	MaxSize    int    // 单个文件的最大大小 MB
+	MaxAge     int    // 备份保留天数
+	MaxBackups int    // 备份保留个数
+}
+
+// loadTraceConfig 从配置文件读取调用段导出配置
+func loadTraceConfig(module string) TraceConfig {
+	return TraceConfig{
+		Enable:     qconfig.Get(module, "trace.enable", false),
+		Path:       qconfig.Get(module, "trace.path", "./log/trace.log"),
+		MaxSize:    qconfig.Get(module, "trace.maxSize", 10),
+		MaxAge:     qconfig.Get(module, "trace.maxAge", 7),
+		MaxBackups: qconfig.Get(module, "trace.maxBackups", 10),
+	}
+}
+
+// SetTrace 设置调用段导出配置
+func (s *Setting) SetTrace(config TraceConfig) *Setting {
+	s.trace = config
+	return s
+}
+
+// tracer 调用段导出 未开启时只生成链路标识
+type tracer struct {
+	module string
+	writer *qio.RotateWriter
+}
+
+func newTracer(module string, config TraceConfig) *tracer {
+	t := &tracer{module: module}
+	if config.Enable {
+		if config.Path == "" {
+			config.Path = "./log/trace.log"
+		}
+		t.writer = qio.GetRotateWriter(config.Path, int64(config.MaxSize)*1024*1024,
+			time.Duration(config.MaxAge)*24*time.Hour, config.MaxBackups)
+	}
+	return t
+}
+
+// activeSpan 进行中的调用段
+type activeSpan struct {
+	Span
+	start  time.Time
+	tracer *tracer
+}
+
+// start 开始调用段 parent无效时开始新的链路
+func (t *tracer) start(kind, route, peer string, parent SpanContext) *activeSpan {
+	traceId := parent.TraceId
+	if traceId == "" {
+		traceId = newTraceId()
+	}
+	return t.startWith(kind, route, peer, SpanContext{TraceId: traceId, SpanId: newSpanId()}, parent.SpanId)
+}
+
+// startWith 使用已生成的链路标识开始调用段
+func (t *tracer) startWith(kind, route, peer string, sc SpanContext, parentSpanId string) *activeSpan {
+	now := time.Now()
+	return &activeSpan{
+		Span: Span{
+			TraceId:      sc.TraceId,
+			SpanId:       sc.SpanId,
+			ParentSpanId: parentSpanId,
+			Module:       t.module,
+			Kind:         kind,
+			Route:        route,
+			Peer:         peer,
+			Start:        now.Format(reqTimeFormat),
+		},
+		start:  now,
+		tracer: t,
+	}
+}
+
+// context 调用段的链路标识
+func (s *activeSpan) context() SpanContext {
+	return SpanContext{TraceId: s.TraceId, SpanId: s.SpanId}
+}
+
+// end 结束调用段并导出
+func (s *activeSpan) end(code easyCon.EResp, err error) {
+	if s.tracer.writer == nil {
+		return
+	}
+	s.DurationMs = float64(time.Since(s.start)) / float64(time.Millisecond)
+	s.Code = code
+	if err != nil {
+		s.Error = err.Error()
+	}
+	js, e := json.Marshal(s.Span)
+	if e != nil {
+		return
+	}
+	_, _ = s.tracer.writer.Write(append(js, '\n'))
+}
+
+// LoggerCtx 返回附加了上下文链路标识的模块日志
+func (serv *MicroService) LoggerCtx(ctx context.Context) *qlog.Logger {
+	if sc, ok := SpanFromContext(ctx); ok {
+		return serv.logger.With("TraceId", sc.TraceId, "SpanId", sc.SpanId)
+	}
+	return serv.logger
+}
+
+// newTraceId 16字节随机数的16进制
+func newTraceId() string {
+	return randomHex(16)
+}
+
+// newSpanId 8字节随机数的16进制
+func newSpanId() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,413 @@
+package qservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kamioair/quick-utils/qconfig"
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qio"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文件分块传输 断点续传，传输完成后校验sha256
+//
+//	// 接收方开启文件传输，文件保存在dir下
+//	setting.EnableFileTransfer("./files")
+//
+//	// 发送方
+//	err := serv.UploadFile(ctx, "FileServer", "./a.zip", "backup/a.zip", qservice.WithChunkSize(512*1024))
+//	err = serv.DownloadFile(ctx, "FileServer", "backup/a.zip", "./b.zip")
+//
+// 传输中的文件以.part结尾，中断后再次调用会从已传输的位置继续
+// 发送方每传输一块发送通知FileProgress
+
+const (
+	defChunkSize  = 256 * 1024
+	maxChunkSize  = 4 * 1024 * 1024
+	partSuffix    = ".part"
+	progressRoute = "FileProgress"
+	maxResync     = 3 // 续传位置不一致时的最大重新同步次数
+)
+
+// fileRoutes 开启文件传输后的内置路由 未开启时不处理，可以由模块自己注册
+var fileRoutes = []string{"FileStat", "FileUpload", "FileDownload"}
+
+// FileStat 内置路由FileStat的响应
+type FileStat struct {
+	Name    string
+	Exists  bool   // 文件是否已传输完成
+	Size    int64  // 文件大小
+	Sha256  string // 文件内容的sha256
+	Partial int64  // 未完成的上传已接收的大小
+}
+
+// FileChunk 文件块 内置路由FileUpload的请求和FileDownload的响应，Data为base64字符串
+type FileChunk struct {
+	Name   string
+	Offset int64  // 块的起始位置 FileUpload的响应中为已接收的大小
+	Data   []byte `json:",omitempty"`
+	Size   int64  // 文件大小
+	Sha256 string `json:",omitempty"` // 文件内容的sha256 上传最后一块时携带
+	Last   bool   // 是否为最后一块
+}
+
+// FileProgress 传输进度通知
+type FileProgress struct {
+	Module    string // 对方模块
+	Name      string // 对方的文件名
+	Direction string // upload|download
+	Offset    int64  // 已传输的大小
+	Size      int64  // 文件大小
+}
+
+// EnableFileTransfer 开启内置路由FileStat、FileUpload、FileDownload dir为文件的根目录
+// 开启后这三个路由由内置路由处理，模块自己注册的同名路由不再生效
+func (s *Setting) EnableFileTransfer(dir string) *Setting {
+	s.fileDir = dir
+	return s
+}
+
+// loadFileDir 从配置文件读取文件传输的根目录 为空时不开启
+func loadFileDir(module string) string {
+	return qconfig.Get(module, "file.dir", "")
+}
+
+// TransferOption 文件传输选项
+type TransferOption func(o *transferOptions)
+
+type transferOptions struct {
+	chunkSize int
+	progress  func(p FileProgress)
+}
+
+// WithChunkSize 设置块大小 字节，默认256KB，最大4MB
+func WithChunkSize(size int) TransferOption {
+	return func(o *transferOptions) {
+		o.chunkSize = size
+	}
+}
+
+// WithProgress 设置进度回调 与FileProgress通知同时触发
+func WithProgress(fn func(p FileProgress)) TransferOption {
+	return func(o *transferOptions) {
+		o.progress = fn
+	}
+}
+
+func newTransferOptions(opts []TransferOption) *transferOptions {
+	o := &transferOptions{chunkSize: defChunkSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.chunkSize <= 0 {
+		o.chunkSize = defChunkSize
+	} else if o.chunkSize > maxChunkSize {
+		o.chunkSize = maxChunkSize
+	}
+	return o
+}
+
+// UploadFile 分块上传本地文件 对方已有相同内容的文件时直接返回
+func (serv *MicroService) UploadFile(ctx context.Context, module, localPath, remoteName string, opts ...TransferOption) error {
+	o := newTransferOptions(opts)
+	sum, size, err := fileSha256(localPath)
+	if err != nil {
+		return err
+	}
+	stat, err := CallCtx[FileStat](ctx, serv, module, "FileStat", map[string]any{"Name": remoteName}, serv.retryOptions()...)
+	if err != nil {
+		return err
+	}
+	progress := FileProgress{Module: module, Name: remoteName, Direction: "upload", Size: size}
+	if stat.Exists && stat.Sha256 == sum {
+		progress.Offset = size
+		serv.reportProgress(ctx, o, progress)
+		return nil
+	}
+
+	offset := stat.Partial
+	if offset > size {
+		offset = 0
+	}
+	for resync := 0; ; {
+		data, err := qio.ReadChunk(localPath, offset, o.chunkSize)
+		if err != nil {
+			return err
+		}
+		chunk := FileChunk{Name: remoteName, Offset: offset, Data: data, Size: size}
+		if chunk.Last = offset+int64(len(data)) >= size; chunk.Last {
+			chunk.Sha256 = sum
+		}
+		rs, err := CallCtx[FileChunk](ctx, serv, module, "FileUpload", chunk, serv.retryOptions()...)
+		if err != nil {
+			// 对方已接收的大小与本地不一致时从对方的位置继续
+			if pos, ok := resyncOffset(err); ok && resync < maxResync && pos <= size {
+				offset = pos
+				resync++
+				continue
+			}
+			return err
+		}
+		resync = 0
+		offset = rs.Offset
+		progress.Offset = offset
+		serv.reportProgress(ctx, o, progress)
+		if chunk.Last {
+			return nil
+		}
+	}
+}
+
+// DownloadFile 分块下载对方的文件 本地存在未完成的下载时继续
+func (serv *MicroService) DownloadFile(ctx context.Context, module, remoteName, localPath string, opts ...TransferOption) error {
+	o := newTransferOptions(opts)
+	stat, err := CallCtx[FileStat](ctx, serv, module, "FileStat", map[string]any{"Name": remoteName}, serv.retryOptions()...)
+	if err != nil {
+		return err
+	}
+	if !stat.Exists {
+		return fmt.Errorf("file %s not found in %s", remoteName, module)
+	}
+
+	part := localPath + partSuffix
+	offset := qio.FileSize(part)
+	if offset > stat.Size {
+		offset = 0
+	}
+	// 创建或截断未完成的文件
+	if offset, err = qio.WriteChunk(part, offset, nil); err != nil {
+		return err
+	}
+	progress := FileProgress{Module: module, Name: remoteName, Direction: "download", Offset: offset, Size: stat.Size}
+	for offset < stat.Size {
+		req := map[string]any{"Name": remoteName, "Offset": offset, "Length": o.chunkSize}
+		chunk, err := CallCtx[FileChunk](ctx, serv, module, "FileDownload", req, serv.retryOptions()...)
+		if err != nil {
+			return err
+		}
+		if len(chunk.Data) == 0 && !chunk.Last {
+			return fmt.Errorf("file %s download stalled at %d", remoteName, offset)
+		}
+		if offset, err = qio.WriteChunk(part, offset, chunk.Data); err != nil {
+			return err
+		}
+		progress.Offset = offset
+		serv.reportProgress(ctx, o, progress)
+		if chunk.Last {
+			break
+		}
+	}
+
+	// 校验完整性 不一致时删除，下次重新下载
+	sum, err := qio.FileSha256(part)
+	if err != nil {
+		return err
+	}
+	if sum != stat.Sha256 {
+		_ = os.Remove(part)
+		return fmt.Errorf("file %s checksum mismatch", remoteName)
+	}
+	return replaceFile(part, localPath)
+}
+
+// reportProgress 发送进度通知并执行进度回调
+func (serv *MicroService) reportProgress(ctx context.Context, o *transferOptions, p FileProgress) {
+	serv.SendNoticeCtx(ctx, progressRoute, p)
+	if o.progress != nil {
+		o.progress(p)
+	}
+}
+
+// retryOptions 按Broker配置的重试次数重试 文件块的请求都是幂等的
+func (serv *MicroService) retryOptions() []CallOption {
+	retry := serv.setting.Broker.Retry - 1
+	if retry < 0 {
+		retry = 0
+	}
+	return []CallOption{WithRetry(retry, 0), WithIdempotent()}
+}
+
+// resyncOffset 从上传位置不一致的错误中获取对方已接收的大小
+func resyncOffset(err error) (int64, bool) {
+	var se *Error
+	if !errors.As(err, &se) || se.Code != easyCon.ERespBadReq || se.Details == nil {
+		return 0, false
+	}
+	pos, ok := se.Details["Offset"]
+	if !ok {
+		return 0, false
+	}
+	n, e := qconvert.ToAnyError[int64](pos)
+	return n, e == nil
+}
+
+// onFileRoute 处理文件传输的内置路由 未开启文件传输或不是文件传输路由时返回false
+func (serv *MicroService) onFileRoute(route string, ctx qdefine.Context) (any, bool) {
+	if serv.setting.fileDir == "" {
+		return nil, false
+	}
+	switch route {
+	case "FileStat":
+		return serv.onFileStat(ctx), true
+	case "FileUpload":
+		return serv.onFileUpload(ctx), true
+	case "FileDownload":
+		return serv.onFileDownload(ctx), true
+	}
+	return nil, false
+}
+
+// isFileRoute 判断是否为已开启的文件传输路由
+func (serv *MicroService) isFileRoute(route string) bool {
+	if serv.setting.fileDir == "" {
+		return false
+	}
+	for _, name := range fileRoutes {
+		if name == route {
+			return true
+		}
+	}
+	return false
+}
+
+// filePath 将请求中的文件名转为根目录下的路径 不允许访问根目录以外的文件
+func (serv *MicroService) filePath(ctx qdefine.Context) (string, string) {
+	name := ctx.GetString("Name")
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) ||
+		strings.HasSuffix(clean, partSuffix) {
+		panic(ErrForbidden.WithMessage("invalid file name").WithDetail("Name", name))
+	}
+	return name, filepath.Join(qio.GetFullPath(serv.setting.fileDir), clean)
+}
+
+// onFileStat 内置路由FileStat 参数Name 文件名
+func (serv *MicroService) onFileStat(ctx qdefine.Context) FileStat {
+	name, path := serv.filePath(ctx)
+	stat := FileStat{Name: name, Partial: qio.FileSize(path + partSuffix)}
+	if isRegularFile(path) {
+		sum, size, err := fileSha256(path)
+		if err != nil {
+			panic(err)
+		}
+		stat.Exists, stat.Size, stat.Sha256 = true, size, sum
+	}
+	return stat
+}
+
+// onFileUpload 内置路由FileUpload 写入一块 最后一块校验后完成文件
+func (serv *MicroService) onFileUpload(ctx qdefine.Context) FileChunk {
+	name, path := serv.filePath(ctx)
+	chunk, err := qconvert.ToAnyError[FileChunk](ctx.Raw())
+	if err != nil {
+		panic(ErrBadReq.WithMessage("invalid file chunk").WithCause(err))
+	}
+	part := path + partSuffix
+	if chunk.Offset < 0 || chunk.Offset > qio.FileSize(part) {
+		panic(ErrBadReq.WithMessage("chunk offset mismatch").WithDetail("Offset", qio.FileSize(part)))
+	}
+	end, err := qio.WriteChunk(part, chunk.Offset, chunk.Data)
+	if err != nil {
+		panic(err)
+	}
+	rs := FileChunk{Name: name, Offset: end, Size: chunk.Size, Last: chunk.Last}
+	if !chunk.Last {
+		return rs
+	}
+
+	// 校验完整性 不一致时删除，发送方需重新上传
+	if end != chunk.Size {
+		_ = os.Remove(part)
+		panic(ErrBadReq.WithMessage("file size mismatch").WithDetail("Size", end))
+	}
+	if chunk.Sha256 != "" {
+		sum, err := qio.FileSha256(part)
+		if err != nil {
+			panic(err)
+		}
+		if sum != chunk.Sha256 {
+			_ = os.Remove(part)
+			panic(ErrBadReq.WithMessage("file checksum mismatch"))
+		}
+	}
+	if err = replaceFile(part, path); err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// onFileDownload 内置路由FileDownload 参数Name 文件名，Offset 起始位置，Length 块大小
+func (serv *MicroService) onFileDownload(ctx qdefine.Context) FileChunk {
+	name, path := serv.filePath(ctx)
+	if !isRegularFile(path) {
+		panic(ErrBadReq.WithMessage("file not found").WithDetail("Name", name))
+	}
+	offset := int64(ctx.GetUIntOr("Offset", 0))
+	length := ctx.GetIntOr("Length", defChunkSize)
+	if length <= 0 || length > maxChunkSize {
+		length = maxChunkSize
+	}
+	data, err := qio.ReadChunk(path, offset, length)
+	if err != nil {
+		panic(err)
+	}
+	size := qio.FileSize(path)
+	return FileChunk{Name: name, Offset: offset, Data: data, Size: size, Last: offset+int64(len(data)) >= size}
+}
+
+// fileHash 文件内容的sha256 文件大小和修改时间不变时沿用
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+var (
+	fileHashes = make(map[string]fileHash)
+	hashLock   sync.Mutex
+)
+
+// fileSha256 计算文件的sha256和大小 按路径缓存，避免每次FileStat都读取整个文件
+func fileSha256(path string) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	hashLock.Lock()
+	h, ok := fileHashes[path]
+	hashLock.Unlock()
+	if ok && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.sum, h.size, nil
+	}
+	sum, err := qio.FileSha256(path)
+	if err != nil {
+		return "", 0, err
+	}
+	hashLock.Lock()
+	fileHashes[path] = fileHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	hashLock.Unlock()
+	return sum, info.Size(), nil
+}
+
+// isRegularFile 判断文件是否存在且不是目录
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// replaceFile 用src替换dst 目标文件存在时先删除
+func replaceFile(src, dst string) error {
+	if qio.PathExists(dst) {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,302 @@
+package qservice
+
+import (
+	"fmt"
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qdefine"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 校验规则写在valid标签中，多个规则用逗号分隔
+//
+//	type AddUserReq struct {
+//		Name     string        `valid:"required,len=2..20"`
+//		Age      int           `valid:"min=0,max=150"`
+//		Sex      string        `valid:"enum=Male|Female"`
+//		Phone    *string       `valid:"regex=^1[0-9]{10}$"`
+//		Birthday *qdefine.Date `valid:"min=1900-01-01,max=2100-12-31"`
+//	}
+//
+//	除required外的规则对零值同样校验，例如min=1时0不通过，可选字段使用指针，为nil时只校验required
+//
+//	required 必须有值（非零值）
+//	min/max  数值比较大小，字符串、数组、字典比较长度，Date/DateTime比较日期
+//	len      长度等于n，或者 len=a..b 在区间内
+//	enum     值必须是列出的其中之一，用|分隔
+//	regex    字符串必须匹配正则，必须是最后一个规则，其后的内容都作为正则
+const validTag = "valid"
+
+// FieldError 字段校验错误
+type FieldError struct {
+	Field   string // 字段路径，例如 Items[0].Name
+	Rule    string // 未通过的规则
+	Message string // 错误信息
+}
+
+var (
+	dateType     = reflect.TypeOf(qdefine.Date(0))
+	dateTimeType = reflect.TypeOf(qdefine.DateTime(0))
+	regexCaches  = sync.Map{}
+)
+
+// Validate 按valid标签校验结构体 不通过时返回400错误，Details.Fields中列出所有不通过的字段
+func Validate(model any) error {
+	errs := make([]FieldError, 0)
+	validateValue(reflect.ValueOf(model), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, fmt.Sprintf("%s %s", e.Field, e.Message))
+	}
+	return ErrBadReq.WithMessage("request param is invalid, "+strings.Join(msgs, "; ")).WithDetail("Fields", errs)
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := fieldName(field)
+			if path != "" {
+				name = path + "." + name
+			}
+			fv := v.Field(i)
+			if tag, ok := field.Tag.Lookup(validTag); ok {
+				validateField(fv, name, tag, errs)
+			}
+			validateValue(fv, name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), errs)
+		}
+	}
+}
+
+func validateField(v reflect.Value, name, tag string, errs *[]FieldError) {
+	for _, rule := range splitRules(tag) {
+		key, arg, _ := strings.Cut(rule, "=")
+		// 未传的可选字段只校验required
+		if key != "required" && isAbsent(v) {
+			continue
+		}
+		if msg := checkRule(v, key, arg); msg != "" {
+			*errs = append(*errs, FieldError{Field: name, Rule: rule, Message: msg})
+		}
+	}
+}
+
+// splitRules 拆分规则 regex之后的内容整体作为正则
+func splitRules(tag string) []string {
+	rules := make([]string, 0)
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			rules = append(rules, tag)
+			break
+		}
+		rule, rest, _ := strings.Cut(tag, ",")
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+		tag = rest
+	}
+	return rules
+}
+
+func checkRule(v reflect.Value, key, arg string) string {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	switch key {
+	case "required":
+		if isEmpty(v) {
+			return "is required"
+		}
+	case "min", "max":
+		cmp, err := compare(v, arg)
+		if err != nil {
+			return err.Error()
+		}
+		if key == "min" && cmp < 0 {
+			return "must not be less than " + arg
+		}
+		if key == "max" && cmp > 0 {
+			return "must not be greater than " + arg
+		}
+	case "len":
+		n, ok := length(v)
+		if !ok {
+			return "does not support len"
+		}
+		lo, hi, isRange := strings.Cut(arg, "..")
+		minLen, err1 := strconv.Atoi(lo)
+		maxLen, err2 := minLen, error(nil)
+		if isRange {
+			maxLen, err2 = strconv.Atoi(hi)
+		}
+		if err1 != nil || err2 != nil {
+			return "has invalid rule len=" + arg
+		}
+		if n < minLen || n > maxLen {
+			if isRange {
+				return fmt.Sprintf("length must be between %d and %d", minLen, maxLen)
+			}
+			return fmt.Sprintf("length must be %d", minLen)
+		}
+	case "enum":
+		str := fmt.Sprintf("%v", v.Interface())
+		for _, item := range strings.Split(arg, "|") {
+			if item == str {
+				return ""
+			}
+		}
+		return "must be one of " + strings.ReplaceAll(arg, "|", ", ")
+	case "regex":
+		if v.Kind() != reflect.String {
+			return "does not support regex"
+		}
+		re, err := getRegex(arg)
+		if err != nil {
+			return "has invalid rule regex=" + arg
+		}
+		if !re.MatchString(v.String()) {
+			return "does not match " + arg
+		}
+	default:
+		return "has unknown rule " + key
+	}
+	return ""
+}
+
+// compare 比较值与规则参数 返回-1 0 1
+func compare(v reflect.Value, arg string) (int, error) {
+	switch v.Type() {
+	case dateType, dateTimeType:
+		t, err := qconvert.DateTime.ToTime(arg)
+		if err != nil {
+			return 0, fmt.Errorf("has invalid date %s", arg)
+		}
+		var bound uint64
+		if v.Type() == dateType {
+			bound = uint64(qdefine.NewDate(t))
+		} else {
+			bound = uint64(qdefine.NewDateTime(t))
+		}
+		return compareFloat(float64(v.Uint()), float64(bound)), nil
+	}
+	if n, ok := length(v); ok {
+		bound, err := strconv.Atoi(arg)
+		if err != nil {
+			return 0, fmt.Errorf("has invalid length %s", arg)
+		}
+		return compareFloat(float64(n), float64(bound)), nil
+	}
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, fmt.Errorf("has invalid number %s", arg)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareFloat(float64(v.Int()), bound), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareFloat(float64(v.Uint()), bound), nil
+	case reflect.Float32, reflect.Float64:
+		return compareFloat(v.Float(), bound), nil
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return compareFloat(float64(d), bound), nil
+	}
+	return 0, fmt.Errorf("does not support min and max")
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// length 字符串按字符数 数组和字典按元素数
+func length(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len(), true
+	}
+	return 0, false
+}
+
+// isAbsent 值为nil指针或nil接口
+func isAbsent(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func getRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCaches.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCaches.Store(pattern, re)
+	return re, nil
+}
+
+// fieldName 优先使用json标签中的名称
+func fieldName(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
@@ -0,0 +1,53 @@
+package qservicetest
+
+import (
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qservice"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"testing"
+	"time"
+)
+
+// ContextOption 请求上下文选项
+type ContextOption func(pack *easyCon.PackReq, timeout *time.Duration)
+
+// From 设置调用方模块 默认为Test
+func From(module string) ContextOption {
+	return func(pack *easyCon.PackReq, _ *time.Duration) {
+		pack.From = module
+	}
+}
+
+// Route 设置请求路由
+func Route(route string) ContextOption {
+	return func(pack *easyCon.PackReq, _ *time.Duration) {
+		pack.Route = route
+	}
+}
+
+// Timeout 设置调用方等待时长 默认没有截止时间
+func Timeout(timeout time.Duration) ContextOption {
+	return func(_ *easyCon.PackReq, t *time.Duration) {
+		*t = timeout
+	}
+}
+
+// NewContext 根据任意内容创建请求上下文 用于直接调用处理方法
+// 内容经过json序列化，与经过MQTT传输的效果一致，测试结束时释放
+//
+//	ctx := qservicetest.NewContext(t, GetUserReq{Id: 1}, qservicetest.Route("GetUser"))
+//	rs, err := onGetUser(ctx)
+func NewContext(t testing.TB, content any, opts ...ContextOption) qdefine.Context {
+	t.Helper()
+	pack := easyCon.PackReq{From: "Test", To: "Test", Content: content}
+	var timeout time.Duration
+	for _, opt := range opts {
+		opt(&pack, &timeout)
+	}
+	ctx, release, err := qservice.NewContext(pack, timeout)
+	if err != nil {
+		t.Fatalf("qservicetest: create context failed, %s", err)
+	}
+	t.Cleanup(release)
+	return ctx
+}
@@ -0,0 +1,298 @@
+package qservicetest
+
+import (
+	"fmt"
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qlog"
+	"github.com/kamioair/quick-utils/qservice"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 使用方式
+//
+//	func TestGetUser(t *testing.T) {
+//		setting := &qservice.Setting{Module: "UserManager"}
+//		setting.BindRouter(router)
+//		h := qservicetest.New(t, setting)
+//		h.Mock("Db", "Query", []User{{Id: 1}})
+//
+//		rs := h.Call("GetUser", GetUserReq{Id: 1}).AssertOK(t)
+//		user := qservicetest.Decode[User](t, rs)
+//		if len(h.Notices()) != 1 { ... }
+//	}
+
+// MockFunc 模拟其他模块处理请求 返回的错误按服务错误转为响应码
+type MockFunc func(ctx qdefine.Context) (any, error)
+
+// Request 服务发出的请求
+type Request struct {
+	Module  string
+	Route   string
+	Content any // 去掉元数据后的请求内容
+}
+
+// Notice 服务发出的通知
+type Notice struct {
+	Route   string
+	Content any // 去掉元数据后的通知内容
+	Retain  bool
+}
+
+// Log 服务通过SendLog发出的日志
+type Log struct {
+	Level   easyCon.ELogLevel
+	Content string
+	Error   string
+}
+
+// Harness 服务测试工具 服务的请求、通知和日志不经过MQTT，由Harness模拟和记录
+type Harness struct {
+	t        testing.TB
+	serv     *qservice.MicroService
+	setting  easyCon.Setting
+	mocks    map[string]MockFunc
+	requests []Request
+	notices  []Notice
+	logs     []Log
+	entries  []*qlog.Entry
+	lock     sync.Mutex
+}
+
+var packId uint64
+
+// New 创建服务测试工具 setting为空时使用模块名Test
+// 会替换setting的访问器工厂和错误日志路径，错误日志在测试结束时删除
+func New(t testing.TB, setting *qservice.Setting) *Harness {
+	t.Helper()
+	if setting == nil {
+		setting = &qservice.Setting{Module: "Test"}
+	}
+	if setting.Broker.TimeOut <= 0 {
+		setting.Broker.TimeOut = 3000
+	}
+	if setting.Broker.Retry <= 0 {
+		setting.Broker.Retry = 1
+	}
+	dir, err := os.MkdirTemp("", "qservicetest")
+	if err != nil {
+		t.Fatalf("qservicetest: create temp dir failed, %s", err)
+	}
+	h := &Harness{t: t, mocks: make(map[string]MockFunc)}
+	setting.SetAdapterFactory(h.newAdapter)
+	setting.SetErrorLog(qservice.ErrorLogConfig{Path: dir + "/error.log"})
+
+	// NewService会切换到程序所在目录，测试中需要还原
+	wd, _ := os.Getwd()
+	h.serv = qservice.NewService(setting)
+	_ = os.Chdir(wd)
+	h.serv.Logger().AddSink(qlog.FuncSink(func(entry *qlog.Entry) {
+		h.lock.Lock()
+		h.entries = append(h.entries, entry)
+		h.lock.Unlock()
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return h
+}
+
+// Service 被测试的服务
+func (h *Harness) Service() *qservice.MicroService {
+	return h.serv
+}
+
+// Mock 模拟其他模块的请求返回resp
+func (h *Harness) Mock(module, route string, resp any) *Harness {
+	return h.MockFunc(module, route, func(qdefine.Context) (any, error) {
+		return resp, nil
+	})
+}
+
+// MockError 模拟其他模块的请求返回错误
+func (h *Harness) MockError(module, route string, err error) *Harness {
+	return h.MockFunc(module, route, func(qdefine.Context) (any, error) {
+		return nil, err
+	})
+}
+
+// MockFunc 模拟其他模块的请求 未模拟的请求返回404
+func (h *Harness) MockFunc(module, route string, fn MockFunc) *Harness {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.mocks[module+"."+route] = fn
+	return h
+}
+
+// Call 向服务发送请求 经过中间件、路由注册表和内置路由，与收到MQTT请求一致
+func (h *Harness) Call(route string, content any) *Response {
+	h.t.Helper()
+	pack := easyCon.PackReq{From: "Test", To: h.setting.Module, Route: route, Content: content}
+	pack.PType = easyCon.EPTypeReq
+	pack.Id = atomic.AddUint64(&packId, 1)
+	if err := qservice.RoundTrip(&pack); err != nil {
+		h.t.Fatalf("qservicetest: request marshal failed, %s", err)
+	}
+	code, resp := h.setting.OnReq(pack)
+	rs := &Response{Code: code, Content: resp}
+	if err := qservice.RoundTrip(&rs.Content); err != nil {
+		h.t.Fatalf("qservicetest: response marshal failed, %s", err)
+	}
+	return rs
+}
+
+// Publish 向服务发送通知 在当前协程中处理
+func (h *Harness) Publish(route string, content any) {
+	h.t.Helper()
+	pack := easyCon.PackNotice{From: "Test", Route: route, Content: content}
+	pack.PType = easyCon.EPTypeNotice
+	pack.Id = atomic.AddUint64(&packId, 1)
+	if err := qservice.RoundTrip(&pack); err != nil {
+		h.t.Fatalf("qservicetest: notice marshal failed, %s", err)
+	}
+	if h.setting.OnNotice != nil {
+		h.setting.OnNotice(pack)
+	}
+}
+
+// Requests 服务发出的请求
+func (h *Harness) Requests() []Request {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Request{}, h.requests...)
+}
+
+// Notices 服务发出的通知
+func (h *Harness) Notices() []Notice {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Notice{}, h.notices...)
+}
+
+// Logs 服务通过SendLog发出的日志
+func (h *Harness) Logs() []Log {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Log{}, h.logs...)
+}
+
+// Entries 服务模块日志的记录
+func (h *Harness) Entries() []*qlog.Entry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]*qlog.Entry{}, h.entries...)
+}
+
+// Clear 清空已记录的请求、通知和日志
+func (h *Harness) Clear() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.requests, h.notices, h.logs, h.entries = nil, nil, nil, nil
+}
+
+// newAdapter 访问器工厂 服务重置时会重新创建
+func (h *Harness) newAdapter(setting easyCon.Setting) easyCon.IAdapter {
+	h.setting = setting
+	if setting.StatusChanged != nil {
+		defer setting.StatusChanged(adapter{h}, easyCon.EStatusLinked)
+	}
+	return adapter{h}
+}
+
+// adapter 模拟访问器
+type adapter struct {
+	h *Harness
+}
+
+func (a adapter) Stop() {}
+
+func (a adapter) Reset() {}
+
+func (a adapter) Req(module, route string, params any) easyCon.PackResp {
+	h := a.h
+	content := qservice.StripMeta(params)
+	// 路由请求转发到目标模块
+	if m, ok := content.(map[string]any); ok && module == "Route" && route == "Request" {
+		module = fmt.Sprintf("%v", m["Module"])
+		route = fmt.Sprintf("%v", m["Route"])
+		content = qservice.StripMeta(m["Content"])
+	}
+	h.lock.Lock()
+	h.requests = append(h.requests, Request{Module: module, Route: route, Content: content})
+	fn, ok := h.mocks[module+"."+route]
+	if !ok {
+		fn, ok = h.mocks[module[strings.LastIndex(module, "/")+1:]+"."+route]
+	}
+	h.lock.Unlock()
+
+	pack := easyCon.PackReq{From: h.setting.Module, To: module, Route: route, Content: content}
+	pack.Id = atomic.AddUint64(&packId, 1)
+	resp := easyCon.PackResp{PackReq: pack}
+	resp.PType = easyCon.EPTypeResp
+	if !ok {
+		resp.RespCode = easyCon.ERespRouteNotFind
+		resp.Content = fmt.Sprintf("qservicetest: no mock for %s.%s", module, route)
+		return resp
+	}
+	ctx, release, err := qservice.NewContext(pack, 0)
+	if err != nil {
+		resp.RespCode = easyCon.ERespBadReq
+		resp.Error = err.Error()
+		return resp
+	}
+	defer release()
+	rs, err := fn(ctx)
+	if err != nil {
+		resp.RespCode, resp.Content = qservice.RespOf(err)
+	} else {
+		resp.RespCode, resp.Content = easyCon.ERespSuccess, rs
+	}
+	if err = qservice.RoundTrip(&resp); err != nil {
+		resp.RespCode, resp.Content, resp.Error = easyCon.ERespError, nil, err.Error()
+	}
+	return resp
+}
+
+func (a adapter) SendNotice(route string, content any) error {
+	return a.notice(route, content, false)
+}
+
+func (a adapter) SendRetainNotice(route string, content any) error {
+	return a.notice(route, content, true)
+}
+
+func (a adapter) notice(route string, content any, retain bool) error {
+	if err := qservice.RoundTrip(&content); err != nil {
+		return err
+	}
+	a.h.lock.Lock()
+	defer a.h.lock.Unlock()
+	a.h.notices = append(a.h.notices, Notice{Route: route, Content: qservice.StripMeta(content), Retain: retain})
+	return nil
+}
+
+func (a adapter) Debug(content string) {
+	a.log(easyCon.ELogLevelDebug, content, nil)
+}
+
+func (a adapter) Warn(content string) {
+	a.log(easyCon.ELogLevelWarning, content, nil)
+}
+
+func (a adapter) Err(content string, err error) {
+	a.log(easyCon.ELogLevelError, content, err)
+}
+
+func (a adapter) log(level easyCon.ELogLevel, content string, err error) {
+	l := Log{Level: level, Content: content}
+	if err != nil {
+		l.Error = err.Error()
+	}
+	a.h.lock.Lock()
+	defer a.h.lock.Unlock()
+	a.h.logs = append(a.h.logs, l)
+}
@@ -0,0 +1,173 @@
+package qservicetest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kamioair/quick-utils/qdefine"
+	"github.com/kamioair/quick-utils/qservice"
+	"github.com/kamioair/quick-utils/qservicetest"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+)
+
+type user struct {
+	Id   int
+	Name string
+}
+
+// newHarness 创建UserManager模块的测试工具 GetUser通过Db.Query查询用户
+func newHarness(t *testing.T) *qservicetest.Harness {
+	var h *qservicetest.Harness
+	router := qservice.NewRouter()
+	router.HandleFunc("GetUser", func(ctx qdefine.Context) (any, error) {
+		id := ctx.GetIntOr("Id", 0)
+		if id <= 0 {
+			return nil, qservice.ErrBadReq.WithMessage("invalid id")
+		}
+		users, err := qservice.CallCtx[[]user](ctx.Context(), h.Service(), "Db", "Query", map[string]any{"Id": id})
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, qservice.ErrBadReq.WithMessage("user not found")
+		}
+		ctx.Logger().Info("user loaded")
+		h.Service().SendNoticeCtx(ctx.Context(), "UserLoaded", users[0])
+		h.Service().SendLog(qdefine.ELogWarn, "slow query", nil)
+		return users[0], nil
+	})
+	var pings []string
+	router.HandleNoticeFunc("Ping", func(ctx qdefine.Context) {
+		pings = append(pings, ctx.GetString("From"))
+		h.Service().SendNotice("Pong", map[string]any{"Count": len(pings)})
+	})
+	setting := &qservice.Setting{Module: "UserManager"}
+	setting.BindRouter(router)
+	h = qservicetest.New(t, setting)
+	return h
+}
+
+func TestCall(t *testing.T) {
+	h := newHarness(t)
+	h.Mock("Db", "Query", []user{{Id: 1, Name: "admin"}})
+
+	rs := h.Call("GetUser", map[string]any{"Id": 1}).AssertOK(t)
+	if got := qservicetest.Decode[user](t, rs); got != (user{Id: 1, Name: "admin"}) {
+		t.Fatalf("response %+v", got)
+	}
+
+	reqs := h.Requests()
+	if len(reqs) != 1 || reqs[0].Module != "Db" || reqs[0].Route != "Query" {
+		t.Fatalf("requests %+v", reqs)
+	}
+	if m, ok := reqs[0].Content.(map[string]any); !ok || fmt.Sprint(m["Id"]) != "1" {
+		t.Fatalf("request content %+v", reqs[0].Content)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	h := newHarness(t)
+	rs := h.Call("GetUser", map[string]any{"Id": 0}).AssertCode(t, easyCon.ERespBadReq)
+	if rs.Message() != "invalid id" {
+		t.Fatalf("message %q", rs.Message())
+	}
+
+	// 未模拟的请求返回404，处理方法原样返回错误
+	h.Call("GetUser", map[string]any{"Id": 1}).AssertCode(t, easyCon.ERespRouteNotFind)
+
+	h.MockError("Db", "Query", qservice.ErrForbidden.WithMessage("no permission"))
+	rs = h.Call("GetUser", map[string]any{"Id": 1}).AssertCode(t, easyCon.ERespForbidden)
+	if rs.Message() != "no permission" {
+		t.Fatalf("message %q", rs.Message())
+	}
+
+	h.Call("Missing", nil).AssertCode(t, easyCon.ERespRouteNotFind)
+}
+
+func TestMockFunc(t *testing.T) {
+	h := newHarness(t)
+	h.MockFunc("Db", "Query", func(ctx qdefine.Context) (any, error) {
+		if ctx.GetInt("Id") != 2 {
+			return []user{}, nil
+		}
+		return []user{{Id: 2, Name: "guest"}}, nil
+	})
+	h.Call("GetUser", map[string]any{"Id": 1}).AssertCode(t, easyCon.ERespBadReq)
+	rs := h.Call("GetUser", map[string]any{"Id": 2}).AssertOK(t)
+	if got := qservicetest.Decode[user](t, rs); got.Name != "guest" {
+		t.Fatalf("response %+v", got)
+	}
+}
+
+func TestCaptures(t *testing.T) {
+	h := newHarness(t)
+	h.Mock("Db", "Query", []user{{Id: 1, Name: "admin"}})
+	h.Call("GetUser", map[string]any{"Id": 1}).AssertOK(t)
+
+	notices := h.Notices()
+	if len(notices) != 1 || notices[0].Route != "UserLoaded" || notices[0].Retain {
+		t.Fatalf("notices %+v", notices)
+	}
+	if m, ok := notices[0].Content.(map[string]any); !ok || m["Name"] != "admin" {
+		t.Fatalf("notice content %+v", notices[0].Content)
+	}
+
+	logs := h.Logs()
+	if len(logs) != 1 || logs[0].Level != easyCon.ELogLevelWarning || logs[0].Content != "slow query" {
+		t.Fatalf("logs %+v", logs)
+	}
+
+	// 处理方法的日志携带链路标识
+	found := false
+	for _, entry := range h.Entries() {
+		if entry.Message != "user loaded" {
+			continue
+		}
+		for _, f := range entry.Fields {
+			if f.Key == "TraceId" && f.Value != "" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("entry with TraceId not found in %d entries", len(h.Entries()))
+	}
+
+	h.Clear()
+	if len(h.Requests())+len(h.Notices())+len(h.Logs())+len(h.Entries()) != 0 {
+		t.Fatal("captures not cleared")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := newHarness(t)
+	h.Publish("Ping", map[string]any{"From": "Test"})
+	notices := h.Notices()
+	if len(notices) != 1 || notices[0].Route != "Pong" {
+		t.Fatalf("notices %+v", notices)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	h := newHarness(t)
+	info := qservicetest.Decode[qservice.ModuleInfo](t, h.Call("@Info", nil))
+	if info.Module != "UserManager" {
+		t.Fatalf("info %+v", info)
+	}
+}
+
+func TestAssertErrCode(t *testing.T) {
+	qservicetest.AssertErrCode(t, nil, easyCon.ERespSuccess)
+	qservicetest.AssertErrCode(t, qservice.ErrTimeout, easyCon.ERespTimeout)
+	qservicetest.AssertErrCode(t, errors.New("404"), easyCon.ERespRouteNotFind)
+	qservicetest.AssertErrCode(t, errors.New("boom"), easyCon.ERespError)
+}
+
+func TestNewContext(t *testing.T) {
+	ctx := qservicetest.NewContext(t, map[string]any{"Items": []any{map[string]any{"Name": "a"}}},
+		qservicetest.Route("GetUser"), qservicetest.From("Web"))
+	if ctx.Route() != "GetUser" || ctx.Source() != "Web" || ctx.GetString("Items[0].Name") != "a" {
+		t.Fatalf("context %s %s %s", ctx.Route(), ctx.Source(), ctx.GetString("Items[0].Name"))
+	}
+}
@@ -0,0 +1,65 @@
+package qservicetest
+
+import (
+	"github.com/kamioair/quick-utils/qconvert"
+	"github.com/kamioair/quick-utils/qservice"
+	easyCon "github.com/qiu-tec/easy-con.golang"
+	"testing"
+)
+
+// Response 服务处理请求的响应
+type Response struct {
+	Code    easyCon.EResp
+	Content any
+}
+
+// Message 异常响应的错误信息
+func (r *Response) Message() string {
+	switch c := r.Content.(type) {
+	case string:
+		return c
+	case map[string]any:
+		if msg, ok := c["Message"].(string); ok {
+			return msg
+		}
+	}
+	return ""
+}
+
+// AssertCode 断言响应码
+func (r *Response) AssertCode(t testing.TB, code easyCon.EResp) *Response {
+	t.Helper()
+	if r.Code != code {
+		t.Fatalf("qservicetest: response code %d, want %d, content %v", r.Code, code, r.Content)
+	}
+	return r
+}
+
+// AssertOK 断言响应成功
+func (r *Response) AssertOK(t testing.TB) *Response {
+	t.Helper()
+	return r.AssertCode(t, easyCon.ERespSuccess)
+}
+
+// Decode 将响应内容解析为T 响应失败或解析失败时测试失败
+func Decode[T any](t testing.TB, r *Response) T {
+	t.Helper()
+	r.AssertOK(t)
+	out, err := qconvert.ToAnyError[T](r.Content)
+	if err != nil {
+		t.Fatalf("qservicetest: response decode failed, %s", err)
+	}
+	return out
+}
+
+// AssertErrCode 断言处理方法返回的错误对应的响应码 兼容errors.New("404")的旧写法
+func AssertErrCode(t testing.TB, err error, code easyCon.EResp) {
+	t.Helper()
+	got := easyCon.ERespSuccess
+	if err != nil {
+		got, _ = qservice.RespOf(err)
+	}
+	if got != code {
+		t.Fatalf("qservicetest: error code %d, want %d, error %v", got, code, err)
+	}
+}
@@ -2,7 +2,7 @@ package qtcp
 
 import (
 	"errors"
-	"fmt"
+	"github.com/kamioair/quick-utils/qlog"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -35,6 +35,39 @@ func (client *client) GetId() int64 {
 	return client.connection.GetId()
 }
 
+// SetCodec 设置包体编解码
+func (client *client) SetCodec(codec Codec) error {
+	if err := checkCodec(client.protocol, codec); err != nil {
+		return err
+	}
+	client.codec = codec
+	return nil
+}
+
+// GetAttr 获取当前连接的属性
+func (client *client) GetAttr(key string) (any, bool) {
+	if client.connection == nil {
+		return nil, false
+	}
+	return client.connection.GetAttr(key)
+}
+
+// SetAttr 设置当前连接的属性 重连后属性不保留
+func (client *client) SetAttr(key string, value any) {
+	if client.connection == nil {
+		return
+	}
+	client.connection.SetAttr(key, value)
+}
+
+// DeleteAttr 删除当前连接的属性
+func (client *client) DeleteAttr(key string) {
+	if client.connection == nil {
+		return
+	}
+	client.connection.DeleteAttr(key)
+}
+
 func NewClient(svrAddr string, buffLength int, protocol PackProtocol, callback ConnCallback, relinkWaitTime, keepAlivePeriod time.Duration) Client {
 	if protocol == nil {
 		panic("tcp.NewClient: protoc can not be nil")
@@ -157,9 +190,9 @@ func (client *client) OnErrored(e error, c Connection) {
 func (client *client) CallRelink() {
 	select {
 	case client.relinkChan <- struct{}{}:
-		fmt.Println("relink signal sent")
+		qlog.Debug("tcp relink signal sent")
 	default:
 		//正在relink中，不需要relink
-		fmt.Println("relinkChan is full doesn't need to call")
+		qlog.Debug("tcp relinking, signal skipped")
 	}
 }
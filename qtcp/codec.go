@@ -0,0 +1,306 @@
+package qtcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrCodecNotSupported = errors.New("packet does not support codec")
+	ErrCodecHATProtocol  = errors.New("codec can not be used with head-and-tail protocol")
+	ErrInvalidAesKey     = errors.New("aes key length must be 16, 24 or 32")
+	ErrCipherTooShort    = errors.New("cipher body is too short")
+)
+
+// AttrAesKey 连接属性中保存的AES密钥 用于按连接轮换密钥
+const AttrAesKey = "qtcp.aesKey"
+
+// Codec 包体编解码 位于断帧协议与回调之间 只处理包体 包头和类型保持明文
+// 编码后的包体为二进制 可能包含包尾字节 只能配合固定头协议使用
+type Codec interface {
+	// Encode 发送前编码
+	Encode(c Connection, body []byte) ([]byte, error)
+	// Decode 接收后解码
+	Decode(c Connection, body []byte) ([]byte, error)
+}
+
+// rebuilder 可以替换包体后重新组包的包
+type rebuilder interface {
+	rebuild(protocol PackProtocol, body []byte) (Packet, error)
+}
+
+type ECompressType string
+
+const (
+	ECompressTypeNone ECompressType = "none"
+	ECompressTypeZlib ECompressType = "zlib"
+	ECompressTypeGzip ECompressType = "gzip"
+)
+
+type EAesMode string
+
+const (
+	EAesModeNone EAesMode = "none"
+	EAesModeCBC  EAesMode = "cbc"
+	EAesModeGCM  EAesMode = "gcm"
+)
+
+// codecChain 编解码链 编码按顺序执行 解码按逆序执行
+type codecChain struct {
+	codecs []Codec
+}
+
+// NewCodecChain 创建编解码链 例如先压缩再加密 NewCodecChain(compress, aes)
+func NewCodecChain(codecs ...Codec) Codec {
+	list := make([]Codec, 0, len(codecs))
+	for _, c := range codecs {
+		if c != nil {
+			list = append(list, c)
+		}
+	}
+	return &codecChain{codecs: list}
+}
+
+func (chain *codecChain) Encode(c Connection, body []byte) ([]byte, error) {
+	var err error
+	for _, codec := range chain.codecs {
+		if body, err = codec.Encode(c, body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func (chain *codecChain) Decode(c Connection, body []byte) ([]byte, error) {
+	var err error
+	for i := len(chain.codecs) - 1; i >= 0; i-- {
+		if body, err = chain.codecs[i].Decode(c, body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// compressCodec 压缩
+type compressCodec struct {
+	compressType ECompressType
+}
+
+// NewCompressCodec 创建压缩编解码
+func NewCompressCodec(compressType ECompressType) Codec {
+	return &compressCodec{compressType: compressType}
+}
+
+func (codec *compressCodec) Encode(_ Connection, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec.compressType {
+	case ECompressTypeZlib:
+		w = zlib.NewWriter(&buf)
+	case ECompressTypeGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return body, nil
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec *compressCodec) Decode(_ Connection, body []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch codec.compressType {
+	case ECompressTypeZlib:
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case ECompressTypeGzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// aesCodec AES加解密 IV或Nonce随机生成并放在密文前
+type aesCodec struct {
+	mode EAesMode
+	key  []byte
+}
+
+// NewAesCodec 创建AES编解码 key为预共享密钥 可通过RotateKey按连接替换
+func NewAesCodec(mode EAesMode, key []byte) (Codec, error) {
+	if err := checkAesKey(key); err != nil {
+		return nil, err
+	}
+	if mode != EAesModeCBC && mode != EAesModeGCM {
+		return nil, errors.New("aes mode must be cbc or gcm")
+	}
+	return &aesCodec{mode: mode, key: key}, nil
+}
+
+// RotateKey 替换连接的AES密钥 之后该连接的收发都使用新密钥
+func RotateKey(c Connection, key []byte) error {
+	if err := checkAesKey(key); err != nil {
+		return err
+	}
+	c.SetAttr(AttrAesKey, key)
+	return nil
+}
+
+func checkAesKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return ErrInvalidAesKey
+}
+
+func (codec *aesCodec) getKey(c Connection) []byte {
+	if c != nil {
+		if v, ok := c.GetAttr(AttrAesKey); ok {
+			if key, b := v.([]byte); b {
+				return key
+			}
+		}
+	}
+	return codec.key
+}
+
+func (codec *aesCodec) Encode(c Connection, body []byte) ([]byte, error) {
+	block, err := aes.NewCipher(codec.getKey(c))
+	if err != nil {
+		return nil, err
+	}
+	if codec.mode == EAesModeGCM {
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return gcm.Seal(nonce, nonce, body, nil), nil
+	}
+	//CBC PKCS7填充
+	pad := aes.BlockSize - len(body)%aes.BlockSize
+	plain := append(append(make([]byte, 0, len(body)+pad), body...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, aes.BlockSize+len(plain))
+	iv := out[:aes.BlockSize]
+	if _, err = rand.Read(iv); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[aes.BlockSize:], plain)
+	return out, nil
+}
+
+func (codec *aesCodec) Decode(c Connection, body []byte) ([]byte, error) {
+	block, err := aes.NewCipher(codec.getKey(c))
+	if err != nil {
+		return nil, err
+	}
+	if codec.mode == EAesModeGCM {
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) < gcm.NonceSize() {
+			return nil, ErrCipherTooShort
+		}
+		return gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], nil)
+	}
+	if len(body) < aes.BlockSize*2 || len(body)%aes.BlockSize != 0 {
+		return nil, ErrCipherTooShort
+	}
+	plain := make([]byte, len(body)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, body[:aes.BlockSize]).CryptBlocks(plain, body[aes.BlockSize:])
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("aes cbc padding is invalid")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// NewCodecFromConfig 根据配置创建编解码 未配置压缩和加密时返回nil
+func NewCodecFromConfig(module string) (Codec, error) {
+	setting := loadCodecSetting(module)
+	codecs := make([]Codec, 0)
+	compress := ECompressType(strings.ToLower(setting.Compress))
+	if compress != "" && compress != ECompressTypeNone {
+		if compress != ECompressTypeZlib && compress != ECompressTypeGzip {
+			return nil, errors.New("unknown compress type " + setting.Compress)
+		}
+		codecs = append(codecs, NewCompressCodec(compress))
+	}
+	mode := EAesMode(strings.ToLower(setting.Aes.Mode))
+	if mode != "" && mode != EAesModeNone {
+		key, err := hex.DecodeString(setting.Aes.Key)
+		if err != nil {
+			return nil, err
+		}
+		aesCodec, err := NewAesCodec(mode, key)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, aesCodec)
+	}
+	if len(codecs) == 0 {
+		return nil, nil
+	}
+	return NewCodecChain(codecs...), nil
+}
+
+// checkCodec 检查协议是否支持编解码 头尾断帧协议按包尾断帧，编码后的包体可能包含包尾
+func checkCodec(protocol PackProtocol, codec Codec) error {
+	if codec == nil {
+		return nil
+	}
+	if _, ok := protocol.(*hatProtocol); ok {
+		return ErrCodecHATProtocol
+	}
+	return nil
+}
+
+// encodePacket 对发送包的包体编码并重新组包
+func (conn *connection) encodePacket(packet Packet) (Packet, error) {
+	rb, ok := packet.(rebuilder)
+	if !ok {
+		return nil, ErrCodecNotSupported
+	}
+	_, body := packet.Split()
+	body, err := conn.codec.Encode(conn, body)
+	if err != nil {
+		return nil, err
+	}
+	return rb.rebuild(conn.protocol, body)
+}
+
+// decodePacket 对接收包的包体解码并重新组包
+func (conn *connection) decodePacket(packet Packet) (Packet, error) {
+	rb, ok := packet.(rebuilder)
+	if !ok {
+		return nil, ErrCodecNotSupported
+	}
+	_, body := packet.Split()
+	body, err := conn.codec.Decode(conn, body)
+	if err != nil {
+		return nil, err
+	}
+	return rb.rebuild(conn.protocol, body)
+}
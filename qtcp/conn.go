@@ -11,7 +11,8 @@ import (
 // Error type
 
 var (
-	ErrConnClosed = errors.New("the connection has been closed")
+	ErrConnClosed       = errors.New("the connection has been closed")
+	ErrIdentityNotBound = errors.New("the identity is not bound to any connection")
 	//ErrWriteBlocked = errors.New("write packet was blocking")
 )
 
@@ -30,6 +31,9 @@ type connection struct {
 	buf []byte
 	//关闭单例 保证关闭仅被执行一次
 	closeOnce *sync.Once
+	//连接属性
+	attrs  map[string]any
+	attrMu sync.RWMutex
 
 	baseInfo
 }
@@ -55,6 +59,7 @@ func newConn(id int64, c *net.TCPConn, baseInfo baseInfo, KeepAlivePeriod time.D
 		buf:       make([]byte, 0),
 		baseInfo:  baseInfo,
 		closeOnce: &sync.Once{},
+		attrs:     make(map[string]any),
 	}
 
 }
@@ -78,9 +83,35 @@ func (conn *connection) close() {
 		//close(conn.myCloseChan)
 		_ = conn.rawConn.Close()
 		conn.callback.OnClosed(conn)
+		//回调结束后再清空属性 保证关闭回调中仍可读取
+		conn.attrMu.Lock()
+		conn.attrs = make(map[string]any)
+		conn.attrMu.Unlock()
 	})
 }
 
+// GetAttr 获取连接属性
+func (conn *connection) GetAttr(key string) (any, bool) {
+	conn.attrMu.RLock()
+	defer conn.attrMu.RUnlock()
+	v, ok := conn.attrs[key]
+	return v, ok
+}
+
+// SetAttr 设置连接属性
+func (conn *connection) SetAttr(key string, value any) {
+	conn.attrMu.Lock()
+	defer conn.attrMu.Unlock()
+	conn.attrs[key] = value
+}
+
+// DeleteAttr 删除连接属性
+func (conn *connection) DeleteAttr(key string) {
+	conn.attrMu.Lock()
+	defer conn.attrMu.Unlock()
+	delete(conn.attrs, key)
+}
+
 func (conn *connection) IsClosed() bool {
 	return atomic.LoadInt32(&conn.closedFlag) == 1
 }
@@ -96,6 +127,11 @@ func (conn *connection) Send(packet Packet, timeout time.Duration) (err error) {
 	//		e = ErrConnClosed
 	//	}
 	//}()
+	if conn.codec != nil {
+		if packet, err = conn.encodePacket(packet); err != nil {
+			return
+		}
+	}
 	if timeout > 0 {
 		conn.rawConn.SetWriteDeadline(time.Now().Add(timeout))
 	}
@@ -211,6 +247,14 @@ func (conn *connection) handle() {
 			if !b { //recChan已经关闭
 				return
 			}
+			if conn.codec != nil { //解码失败的包丢弃 不影响后续包
+				p, err := conn.decodePacket(packet)
+				if err != nil {
+					conn.callback.OnErrored(err, conn)
+					continue
+				}
+				packet = p
+			}
 			conn.callback.OnReceived(conn, packet)
 		}
 	}
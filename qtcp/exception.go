@@ -1,6 +1,6 @@
 package qtcp
 
-import "fmt"
+import "github.com/kamioair/quick-utils/qlog"
 
 // Check 检测并抛出异常
 func Check(e error) {
@@ -12,7 +12,7 @@ func Check(e error) {
 // Recover 收集异常
 func Recover() error {
 	if err := recover(); err != nil {
-		fmt.Println(err)
+		qlog.Error("tcp recover", "Error", err)
 		return err.(error)
 	}
 	return nil
@@ -13,6 +13,8 @@ type baseInfo struct {
 	callback ConnCallback
 	//协议
 	protocol PackProtocol
+	//包体编解码 可为空
+	codec Codec
 
 	//关闭
 	closeChan chan struct{}
@@ -21,18 +23,42 @@ type baseInfo struct {
 type Client interface {
 	Connection
 	Stop()
+	// SetCodec 设置包体编解码 需在Start前调用 头尾断帧协议不支持，返回ErrCodecHATProtocol
+	SetCodec(codec Codec) error
 }
 type Server interface {
 	Start()
 	Stop()
+	// SetCodec 设置包体编解码 需在Start前调用 头尾断帧协议不支持，返回ErrCodecHATProtocol
+	SetCodec(codec Codec) error
 	//CloseConnection(id int) (bool, error)
 	//Send(id int, pack Packet) error
+
+	// Bind 将业务标识（如设备序列号）绑定到连接，连接关闭时自动解绑
+	// 如果该标识已绑定了其他未关闭的连接，kickOld为true时关闭并返回旧连接，否则返回旧连接且不做绑定
+	// 绑定成功且没有踢掉旧连接时返回nil
+	Bind(identity string, c Connection, kickOld bool) (old Connection)
+	// Unbind 解除业务标识的绑定
+	Unbind(identity string)
+	// GetConnection 根据业务标识获取连接
+	GetConnection(identity string) (Connection, bool)
+	// SendTo 向业务标识对应的连接发送包
+	SendTo(identity string, pack Packet, timeout time.Duration) error
+	// Kick 关闭业务标识对应的连接
+	Kick(identity string) bool
 }
 type Connection interface {
 	Start()
 	Send(pack Packet, timeout time.Duration) error
 	GetId() int64
 	IsClosed() bool
+
+	// GetAttr 获取连接属性
+	GetAttr(key string) (any, bool)
+	// SetAttr 设置连接属性，连接关闭后自动清空
+	SetAttr(key string, value any)
+	// DeleteAttr 删除连接属性
+	DeleteAttr(key string)
 }
 
 // PackProtocol 封包协议
@@ -52,6 +78,13 @@ type Packet interface {
 	Split() (frameType, body []byte)
 }
 
+// TypedPacket 可以读取包类型的数据包
+type TypedPacket interface {
+	Packet
+	// FrameType 包类型 协议没有包类型时返回nil
+	FrameType() []byte
+}
+
 // ConnCallback 建立连接时的委托定义
 type ConnCallback interface {
 	OnLinked(c Connection)
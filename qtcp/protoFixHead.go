@@ -48,6 +48,16 @@ func (pack *fHPacket) Split() (frameType, body []byte) {
 	return pack.CheckBytes, pack.Body
 }
 
+// FrameType 包类型
+func (pack *fHPacket) FrameType() []byte {
+	return pack.TypeBytes
+}
+
+// rebuild 替换包体后重新组包
+func (pack *fHPacket) rebuild(protocol PackProtocol, body []byte) (Packet, error) {
+	return protocol.BuildFrame(pack.TypeBytes, body)
+}
+
 // fHProtocol 固定包头协议  固定头包 包结构为 特征头-包类型-包长度-正文-校验顺序不能变
 type fHProtocol struct {
 
@@ -52,6 +52,11 @@ func (pack *hATPacket) Split() (frameType, body []byte) {
 	return nil, pack.Body
 }
 
+// FrameType 包类型
+func (pack *hATPacket) FrameType() []byte {
+	return pack.TypeBytes
+}
+
 type hatProtocol struct {
 	//Head  包头
 	Head []byte
@@ -15,6 +15,14 @@ type server struct {
 	baseInfo
 	nextId     int64
 	AcceptChan chan struct{}
+	//业务标识与连接的索引
+	identities map[string]Connection
+	identityMu sync.RWMutex
+}
+
+// closer 可被主动关闭的连接
+type closer interface {
+	close()
 }
 
 func NewServer(port int, acceptTimeout, keepAlivePeriod time.Duration, buffLength int, callback ConnCallback, protocol PackProtocol) Server {
@@ -34,9 +42,20 @@ func NewServer(port int, acceptTimeout, keepAlivePeriod time.Duration, buffLengt
 			//closeOnce:  &sync.Once{},
 			closeChan: make(chan struct{}),
 		},
-		nextId: 0,
+		nextId:     0,
+		identities: make(map[string]Connection),
+	}
+}
+
+// SetCodec 设置包体编解码
+func (server *server) SetCodec(codec Codec) error {
+	if err := checkCodec(server.protocol, codec); err != nil {
+		return err
 	}
+	server.codec = codec
+	return nil
 }
+
 func (server *server) GetNextId() int64 {
 	return atomic.AddInt64(&server.nextId, 1)
 }
@@ -86,6 +105,7 @@ func (server *server) accept(listener *net.TCPListener) {
 	b := server.baseInfo
 	//b.closeOnce = &sync.Once{}
 	b.waitGroup = &sync.WaitGroup{}
+	b.callback = server
 	c := newConn(server.GetNextId(), conn, b, server.keepAlivePeriod)
 	c.Start()
 	//server.callback.OnLinked(c)
@@ -98,3 +118,88 @@ func (server *server) Stop() {
 	server.waitGroup.Wait()
 	//})
 }
+
+// Bind 将业务标识绑定到连接
+func (server *server) Bind(identity string, c Connection, kickOld bool) (old Connection) {
+	server.identityMu.Lock()
+	old, ok := server.identities[identity]
+	if ok && old.GetId() != c.GetId() && !old.IsClosed() && !kickOld {
+		server.identityMu.Unlock()
+		return old
+	}
+	server.identities[identity] = c
+	server.identityMu.Unlock()
+
+	if !ok || old.GetId() == c.GetId() || old.IsClosed() {
+		return nil
+	}
+	//踢掉旧连接 旧连接关闭时不会解绑新连接
+	if cl, b := old.(closer); b {
+		cl.close()
+	}
+	return old
+}
+
+// Unbind 解除业务标识的绑定
+func (server *server) Unbind(identity string) {
+	server.identityMu.Lock()
+	defer server.identityMu.Unlock()
+	delete(server.identities, identity)
+}
+
+// GetConnection 根据业务标识获取连接
+func (server *server) GetConnection(identity string) (Connection, bool) {
+	server.identityMu.RLock()
+	defer server.identityMu.RUnlock()
+	c, ok := server.identities[identity]
+	return c, ok
+}
+
+// SendTo 向业务标识对应的连接发送包
+func (server *server) SendTo(identity string, pack Packet, timeout time.Duration) error {
+	c, ok := server.GetConnection(identity)
+	if !ok {
+		return ErrIdentityNotBound
+	}
+	return c.Send(pack, timeout)
+}
+
+// Kick 关闭业务标识对应的连接
+func (server *server) Kick(identity string) bool {
+	c, ok := server.GetConnection(identity)
+	if !ok {
+		return false
+	}
+	if cl, b := c.(closer); b {
+		cl.close()
+	}
+	return true
+}
+
+// unbindConn 连接关闭时解除其所有绑定
+func (server *server) unbindConn(c Connection) {
+	server.identityMu.Lock()
+	defer server.identityMu.Unlock()
+	for k, v := range server.identities {
+		if v.GetId() == c.GetId() {
+			delete(server.identities, k)
+		}
+	}
+}
+
+func (server *server) OnLinked(c Connection) {
+	server.callback.OnLinked(c)
+}
+
+func (server *server) OnReceived(c Connection, packet Packet) {
+	server.callback.OnReceived(c, packet)
+}
+
+func (server *server) OnClosed(c Connection) {
+	server.callback.OnClosed(c)
+	server.unbindConn(c)
+}
+
+func (server *server) OnErrored(e error, c Connection) {
+	server.callback.OnErrored(e, c)
+}
@@ -0,0 +1,23 @@
+package qtcp
+
+import "github.com/kamioair/quick-utils/qconfig"
+
+type codecSetting struct {
+	Compress string
+	Aes      aesSetting
+}
+
+type aesSetting struct {
+	Mode string
+	Key  string
+}
+
+func loadCodecSetting(module string) codecSetting {
+	return codecSetting{
+		Compress: qconfig.Get(module, "tcp.codec.compress", "none"),
+		Aes: aesSetting{
+			Mode: qconfig.Get(module, "tcp.codec.aes.mode", "none"),
+			Key:  qconfig.Get(module, "tcp.codec.aes.key", ""),
+		},
+	}
+}